@@ -18,6 +18,7 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -76,6 +77,24 @@ type CloudInit struct {
 	RunCmd            []string    `yaml:"runcmd,omitempty"`
 	WriteFiles        []File      `yaml:"write_files,omitempty"`
 	CACerts           CACerts     `yaml:"ca-certs,omitempty"`
+
+	Users        []User               `yaml:"users,omitempty"`
+	BootCmd      []Command            `yaml:"bootcmd,omitempty"`
+	Apt          *Apt                 `yaml:"apt,omitempty"`
+	YumRepos     map[string]YumRepo   `yaml:"yum_repos,omitempty"`
+	Hostname     string               `yaml:"hostname,omitempty"`
+	FQDN         string               `yaml:"fqdn,omitempty"`
+	Timezone     string               `yaml:"timezone,omitempty"`
+	NTP          *NTP                 `yaml:"ntp,omitempty"`
+	Mounts       [][]string           `yaml:"mounts,omitempty"`
+	DiskSetup    map[string]DiskSetup `yaml:"disk_setup,omitempty"`
+	FSSetup      []FSSetup            `yaml:"fs_setup,omitempty"`
+	PowerState   *PowerState          `yaml:"power_state,omitempty"`
+	FinalMessage string               `yaml:"final_message,omitempty"`
+
+	// Extra holds the cloud-config keys that are not modelled by CloudInit. They are
+	// serialized inline, so unknown keys survive a yaml round trip.
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 type CACerts struct {
@@ -83,6 +102,127 @@ type CACerts struct {
 	Trusted        []string `yaml:"trusted"`
 }
 
+// DefaultUserEntry is the entry of the users list that stands for the default user
+// defined in system_info. cloud-init only creates the default user if it is listed.
+const DefaultUserEntry = "default"
+
+// User is an extra user created by cloud-init. A user with only the name set to
+// DefaultUserEntry is serialized as "default".
+type User struct {
+	Name              string   `yaml:"name"`
+	Gecos             string   `yaml:"gecos,omitempty"`
+	Homedir           string   `yaml:"homedir,omitempty"`
+	Shell             string   `yaml:"shell,omitempty"`
+	PrimaryGroup      string   `yaml:"primary_group,omitempty"`
+	Groups            []string `yaml:"groups,omitempty"`
+	Sudo              string   `yaml:"sudo,omitempty"`
+	LockPasswd        *bool    `yaml:"lock_passwd,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+	System            bool     `yaml:"system,omitempty"`
+	NoCreateHome      bool     `yaml:"no_create_home,omitempty"`
+}
+
+// user is used to (un)marshal User without recursing into its custom methods.
+type user User
+
+func (u User) MarshalYAML() (interface{}, error) {
+	if reflect.DeepEqual(u, User{Name: DefaultUserEntry}) {
+		return DefaultUserEntry, nil
+	}
+	return user(u), nil
+}
+
+func (u *User) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*u = User{}
+		return node.Decode(&u.Name)
+	}
+	return node.Decode((*user)(u))
+}
+
+// Command is a command run by cloud-init. It is either a string run by the shell, or a
+// list of arguments that is run directly.
+type Command struct {
+	Shell string
+	Args  []string
+}
+
+func (c Command) MarshalYAML() (interface{}, error) {
+	if len(c.Args) > 0 {
+		return c.Args, nil
+	}
+	return c.Shell, nil
+}
+
+func (c *Command) UnmarshalYAML(node *yaml.Node) error {
+	*c = Command{}
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&c.Args)
+	}
+	return node.Decode(&c.Shell)
+}
+
+type AptSource struct {
+	Source    string `yaml:"source,omitempty"`
+	KeyID     string `yaml:"keyid,omitempty"`
+	Key       string `yaml:"key,omitempty"`
+	Keyserver string `yaml:"keyserver,omitempty"`
+	Filename  string `yaml:"filename,omitempty"`
+}
+
+type Apt struct {
+	Sources map[string]AptSource `yaml:"sources,omitempty"`
+	// Extra holds the apt options that are not modelled, like primary and security.
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+type YumRepo struct {
+	Name       string `yaml:"name,omitempty"`
+	BaseURL    string `yaml:"baseurl,omitempty"`
+	MirrorList string `yaml:"mirrorlist,omitempty"`
+	Enabled    *bool  `yaml:"enabled,omitempty"`
+	GPGCheck   *bool  `yaml:"gpgcheck,omitempty"`
+	GPGKey     string `yaml:"gpgkey,omitempty"`
+	// Extra holds the repo options that are not modelled. cloud-init writes them as is
+	// in the repo file.
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+type NTP struct {
+	Enabled   *bool    `yaml:"enabled,omitempty"`
+	NTPClient string   `yaml:"ntp_client,omitempty"`
+	Servers   []string `yaml:"servers,omitempty"`
+	Pools     []string `yaml:"pools,omitempty"`
+}
+
+type DiskSetup struct {
+	TableType string `yaml:"table_type,omitempty"`
+	// Layout is either true, for a single partition using the whole disk, or a list of
+	// partition sizes, as percentages of the disk.
+	Layout    interface{} `yaml:"layout,omitempty"`
+	Overwrite bool        `yaml:"overwrite,omitempty"`
+}
+
+type FSSetup struct {
+	Label      string   `yaml:"label,omitempty"`
+	Filesystem string   `yaml:"filesystem"`
+	Device     string   `yaml:"device"`
+	Partition  string   `yaml:"partition,omitempty"`
+	Overwrite  bool     `yaml:"overwrite,omitempty"`
+	ReplaceFS  string   `yaml:"replace_fs,omitempty"`
+	ExtraOpts  []string `yaml:"extra_opts,omitempty"`
+}
+
+type PowerState struct {
+	// Mode is one of poweroff, halt or reboot.
+	Mode string `yaml:"mode"`
+	// Delay is either "now" or the number of minutes to wait, as "+5".
+	Delay     string      `yaml:"delay,omitempty"`
+	Message   string      `yaml:"message,omitempty"`
+	Timeout   int         `yaml:"timeout,omitempty"`
+	Condition interface{} `yaml:"condition,omitempty"`
+}
+
 func (c *CloudInit) AddCACert(cert []byte) error {
 	c.mux.Lock()
 	defer c.mux.Unlock()
@@ -164,6 +304,190 @@ func (c *CloudInit) AddFile(contents []byte, path, owner, permissions string) {
 	c.WriteFiles = append(c.WriteFiles, file)
 }
 
+// ensureDefaultUser makes sure the default user is still created when extra users are
+// added. The caller must hold the lock.
+func (c *CloudInit) ensureDefaultUser() {
+	if len(c.Users) == 0 {
+		return
+	}
+	for _, val := range c.Users {
+		if val.Name == DefaultUserEntry {
+			return
+		}
+	}
+	c.Users = append([]User{{Name: DefaultUserEntry}}, c.Users...)
+}
+
+// AddUser adds extra users. Users are unique by name. The default user is kept.
+func (c *CloudInit) AddUser(users ...User) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, usr := range users {
+		found := false
+		for _, val := range c.Users {
+			if val.Name == usr.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.Users = append(c.Users, usr)
+		}
+	}
+	c.ensureDefaultUser()
+}
+
+// AddBootCmd adds a shell command that is run early, on every boot.
+func (c *CloudInit) AddBootCmd(cmd string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.BootCmd = append(c.BootCmd, Command{Shell: cmd})
+}
+
+// AddAptSource adds an apt source. Adding a source with a name that is already used
+// is a no-op.
+func (c *CloudInit) AddAptSource(name string, source AptSource) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.Apt == nil {
+		c.Apt = &Apt{}
+	}
+	if c.Apt.Sources == nil {
+		c.Apt.Sources = map[string]AptSource{}
+	}
+	if _, ok := c.Apt.Sources[name]; ok {
+		return
+	}
+	c.Apt.Sources[name] = source
+}
+
+// AddYumRepo adds a yum repository. Adding a repository with an ID that is already used
+// is a no-op.
+func (c *CloudInit) AddYumRepo(id string, repo YumRepo) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.YumRepos == nil {
+		c.YumRepos = map[string]YumRepo{}
+	}
+	if _, ok := c.YumRepos[id]; ok {
+		return
+	}
+	c.YumRepos[id] = repo
+}
+
+func (c *CloudInit) SetHostname(hostname, fqdn string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.Hostname = hostname
+	c.FQDN = fqdn
+}
+
+func (c *CloudInit) SetTimezone(timezone string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.Timezone = timezone
+}
+
+// AddNTPServer adds NTP servers and enables NTP.
+func (c *CloudInit) AddNTPServer(servers ...string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.NTP == nil {
+		c.NTP = &NTP{}
+	}
+	enabled := true
+	c.NTP.Enabled = &enabled
+	for _, server := range servers {
+		found := false
+		for _, val := range c.NTP.Servers {
+			if val == server {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.NTP.Servers = append(c.NTP.Servers, server)
+		}
+	}
+}
+
+// AddMount adds an fstab entry (device, mount point, fs type, options, dump, pass).
+// Only the device is required. Mounts are unique by device.
+func (c *CloudInit) AddMount(entry ...string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if len(entry) == 0 || entry[0] == "" {
+		return fmt.Errorf("missing mount device")
+	}
+	if len(entry) > 6 {
+		return fmt.Errorf("too many mount fields: %d", len(entry))
+	}
+	for _, val := range c.Mounts {
+		if len(val) > 0 && val[0] == entry[0] {
+			return nil
+		}
+	}
+	c.Mounts = append(c.Mounts, entry)
+	return nil
+}
+
+// AddDiskSetup adds the partitioning of a disk. Adding a disk that is already set up is
+// a no-op.
+func (c *CloudInit) AddDiskSetup(device string, setup DiskSetup) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.DiskSetup == nil {
+		c.DiskSetup = map[string]DiskSetup{}
+	}
+	if _, ok := c.DiskSetup[device]; ok {
+		return
+	}
+	c.DiskSetup[device] = setup
+}
+
+// AddFSSetup adds a filesystem. Filesystems are unique by device and partition.
+func (c *CloudInit) AddFSSetup(fs FSSetup) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, val := range c.FSSetup {
+		if val.Device == fs.Device && val.Partition == fs.Partition {
+			return
+		}
+	}
+	c.FSSetup = append(c.FSSetup, fs)
+}
+
+// SetPowerState sets the power state change done once cloud-init finishes.
+func (c *CloudInit) SetPowerState(state PowerState) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	switch state.Mode {
+	case "poweroff", "halt", "reboot":
+	default:
+		return fmt.Errorf("invalid power state mode: %q", state.Mode)
+	}
+	c.PowerState = &state
+	return nil
+}
+
+func (c *CloudInit) SetFinalMessage(msg string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.FinalMessage = msg
+}
+
 func (c *CloudInit) Serialize() (string, error) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
@@ -0,0 +1,89 @@
+package cloudconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCloudInitHelpers(t *testing.T) {
+	enabled := true
+	cfg := NewDefaultCloudInitConfig()
+	cfg.AddUser(User{Name: "admin", Groups: []string{"wheel"}, Sudo: "ALL=(ALL) NOPASSWD:ALL"})
+	cfg.AddUser(User{Name: "admin"})
+	cfg.AddBootCmd("echo early")
+	cfg.AddAptSource("test", AptSource{Source: "deb http://example.com focal main", KeyID: "ABCD"})
+	cfg.AddAptSource("test", AptSource{Source: "ignored"})
+	cfg.AddYumRepo("test", YumRepo{Name: "Test", BaseURL: "http://example.com/el9", Enabled: &enabled})
+	cfg.SetHostname("runner-1", "runner-1.example.com")
+	cfg.SetTimezone("Europe/Bucharest")
+	cfg.AddNTPServer("ntp1.example.com", "ntp1.example.com", "ntp2.example.com")
+	require.NoError(t, cfg.AddMount("/dev/sdb1", "/data", "ext4"))
+	require.NoError(t, cfg.AddMount("/dev/sdb1", "/ignored"))
+	require.EqualError(t, cfg.AddMount(), "missing mount device")
+	cfg.AddDiskSetup("/dev/sdb", DiskSetup{TableType: "gpt", Layout: true})
+	cfg.AddFSSetup(FSSetup{Label: "data", Filesystem: "ext4", Device: "/dev/sdb", Partition: "auto"})
+	cfg.AddFSSetup(FSSetup{Filesystem: "xfs", Device: "/dev/sdb", Partition: "auto"})
+	require.NoError(t, cfg.SetPowerState(PowerState{Mode: "reboot", Delay: "now"}))
+	require.EqualError(t, cfg.SetPowerState(PowerState{Mode: "sleep"}), `invalid power state mode: "sleep"`)
+	cfg.SetFinalMessage("done")
+
+	asStr, err := cfg.Serialize()
+	require.NoError(t, err)
+
+	decoded := map[string]interface{}{}
+	require.NoError(t, yaml.Unmarshal([]byte(asStr), &decoded))
+	require.Equal(t, []interface{}{
+		"default",
+		map[string]interface{}{"name": "admin", "groups": []interface{}{"wheel"}, "sudo": "ALL=(ALL) NOPASSWD:ALL"},
+	}, decoded["users"])
+	require.Equal(t, []interface{}{"echo early"}, decoded["bootcmd"])
+	require.Equal(t, map[string]interface{}{
+		"sources": map[string]interface{}{
+			"test": map[string]interface{}{"source": "deb http://example.com focal main", "keyid": "ABCD"},
+		},
+	}, decoded["apt"])
+	require.Equal(t, map[string]interface{}{
+		"test": map[string]interface{}{"name": "Test", "baseurl": "http://example.com/el9", "enabled": true},
+	}, decoded["yum_repos"])
+	require.Equal(t, "runner-1", decoded["hostname"])
+	require.Equal(t, "runner-1.example.com", decoded["fqdn"])
+	require.Equal(t, "Europe/Bucharest", decoded["timezone"])
+	require.Equal(t, map[string]interface{}{
+		"enabled": true,
+		"servers": []interface{}{"ntp1.example.com", "ntp2.example.com"},
+	}, decoded["ntp"])
+	require.Equal(t, []interface{}{[]interface{}{"/dev/sdb1", "/data", "ext4"}}, decoded["mounts"])
+	require.Equal(t, map[string]interface{}{
+		"/dev/sdb": map[string]interface{}{"table_type": "gpt", "layout": true},
+	}, decoded["disk_setup"])
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"label": "data", "filesystem": "ext4", "device": "/dev/sdb", "partition": "auto"},
+	}, decoded["fs_setup"])
+	require.Equal(t, map[string]interface{}{"mode": "reboot", "delay": "now"}, decoded["power_state"])
+	require.Equal(t, "done", decoded["final_message"])
+
+	var roundTrip CloudInit
+	require.NoError(t, yaml.Unmarshal([]byte(asStr), &roundTrip))
+	require.Equal(t, cfg.Users, roundTrip.Users)
+	require.Equal(t, cfg.BootCmd, roundTrip.BootCmd)
+	require.Empty(t, roundTrip.Extra)
+}
+
+func TestDefaultConfigHasNoNewKeys(t *testing.T) {
+	asStr, err := NewDefaultCloudInitConfig().Serialize()
+	require.NoError(t, err)
+
+	decoded := map[string]interface{}{}
+	require.NoError(t, yaml.Unmarshal([]byte(asStr), &decoded))
+	require.ElementsMatch(t, []string{"package_upgrade", "packages", "system_info"}, mapKeys(decoded))
+}
+
+func mapKeys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
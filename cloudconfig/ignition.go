@@ -0,0 +1,146 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package cloudconfig
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/cloudconfig/ignition"
+	"github.com/cloudbase/garm-provider-common/defaults"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DistroFlatcar is the distro name of Flatcar Container Linux.
+	DistroFlatcar = "flatcar"
+	// DistroFedoraCoreOS is the distro name of Fedora CoreOS.
+	DistroFedoraCoreOS = "fedora-coreos"
+)
+
+const (
+	// ignitionScriptsDir holds the scripts run on first boot. The root filesystem is read
+	// only on these distros, so they can't be written at the root, like with cloud-init.
+	ignitionScriptsDir = "/var/lib/garm"
+	// ignitionInstallScript is the path of the runner install script.
+	ignitionInstallScript = ignitionScriptsDir + "/install_runner.sh"
+	// ignitionPreInstallDir holds the pre-install scripts.
+	ignitionPreInstallDir = ignitionScriptsDir + "/pre-install"
+)
+
+// ignitionDistros maps the distros that are configured using Ignition to the path where
+// the CA bundle needs to be written in order to be trusted.
+var ignitionDistros = map[string]string{
+	DistroFlatcar:      "/etc/ssl/certs/garm-ca-bundle.pem",
+	DistroFedoraCoreOS: "/etc/pki/ca-trust/source/anchors/garm-ca-bundle.pem",
+	"fcos":             "/etc/pki/ca-trust/source/anchors/garm-ca-bundle.pem",
+}
+
+// ignitionUserGroups holds the supplementary groups of the runner user, for each distro.
+// Ignition fails if a group does not exist, so only groups that ship with the distro are
+// listed.
+var ignitionUserGroups = map[string][]string{
+	DistroFlatcar: {"docker"},
+}
+
+// IsIgnitionDistro returns true if the distro is configured using Ignition instead of
+// cloud-init.
+func IsIgnitionDistro(distro string) bool {
+	_, ok := ignitionDistros[strings.ToLower(distro)]
+	return ok
+}
+
+// GetIgnitionConfig returns the Ignition config for Flatcar and Fedora CoreOS. It is the
+// equivalent of GetCloudInitConfig for distros that don't use cloud-init. The install
+// runner script and pre-install scripts are run once, on first boot, by a systemd unit.
+// Extra packages, the cloud_config and the userdata_parts extra specs are cloud-init
+// features and are rejected, as they can't be honored.
+func GetIgnitionConfig(bootstrapParams params.BootstrapInstance, installScript []byte) (string, error) {
+	extraSpecs, err := GetSpecs(bootstrapParams)
+	if err != nil {
+		return "", errors.Wrap(err, "getting specs")
+	}
+
+	distro := strings.ToLower(extraSpecs.Distro)
+	caBundlePath, ok := ignitionDistros[distro]
+	if !ok {
+		return "", fmt.Errorf("distro %q does not use ignition", extraSpecs.Distro)
+	}
+
+	if len(bootstrapParams.UserDataOptions.ExtraPackages) > 0 {
+		return "", fmt.Errorf("extra packages are not supported on %s", extraSpecs.Distro)
+	}
+	if len(extraSpecs.CloudConfig) > 0 {
+		return "", fmt.Errorf("the cloud_config extra spec is not supported on %s", extraSpecs.Distro)
+	}
+	if len(extraSpecs.UserDataParts) > 0 {
+		return "", fmt.Errorf("the userdata_parts extra spec is not supported on %s", extraSpecs.Distro)
+	}
+
+	cfg := ignition.NewConfig()
+	cfg.AddUser(defaults.DefaultUser, fmt.Sprintf("/home/%s", defaults.DefaultUser), defaults.DefaultUserShell, ignitionUserGroups[distro]...)
+	cfg.AddSSHKey(defaults.DefaultUser, bootstrapParams.SSHKeys...)
+	cfg.AddFile([]byte(fmt.Sprintf("%s ALL=(ALL) NOPASSWD:ALL\n", defaults.DefaultUser)), fmt.Sprintf("/etc/sudoers.d/%s", defaults.DefaultUser), "root:root", 0o440)
+
+	if bootstrapParams.CACertBundle != nil && len(bootstrapParams.CACertBundle) > 0 {
+		if err := cfg.AddCACert(bootstrapParams.CACertBundle, caBundlePath); err != nil {
+			return "", errors.Wrap(err, "adding CA cert bundle")
+		}
+	}
+
+	var execStart []string
+	if len(extraSpecs.PreInstallScripts) > 0 {
+		names := sortMapKeys(extraSpecs.PreInstallScripts)
+		for _, name := range names {
+			script := extraSpecs.PreInstallScripts[name]
+			scriptPath := fmt.Sprintf("%s/%s", ignitionPreInstallDir, name)
+			cfg.AddFile(script, scriptPath, "root:root", 0o755)
+			execStart = append(execStart, fmt.Sprintf("ExecStart=%s", scriptPath))
+		}
+	}
+	execStart = append(execStart, fmt.Sprintf("ExecStart=/usr/bin/rm -rf %s", ignitionPreInstallDir))
+
+	cfg.AddFile(installScript, ignitionInstallScript, "root:root", 0o755)
+	execStart = append(execStart,
+		fmt.Sprintf("ExecStart=/usr/bin/su -l -c %s %s", ignitionInstallScript, defaults.DefaultUser),
+		fmt.Sprintf("ExecStart=/usr/bin/rm -f %s", ignitionInstallScript),
+	)
+	cfg.AddUnit("garm-runner-install.service", fmt.Sprintf(ignitionInstallUnit, ignitionInstallScript, strings.Join(execStart, "\n")), true)
+
+	asStr, err := cfg.Serialize()
+	if err != nil {
+		return "", errors.Wrap(err, "creating ignition config")
+	}
+
+	return asStr, nil
+}
+
+// ignitionInstallUnit runs the install script on first boot. The unit is skipped on
+// subsequent boots, as the install script is removed once it ran.
+const ignitionInstallUnit = `[Unit]
+Description=Install the GitHub Actions runner
+Wants=network-online.target
+After=network-online.target
+ConditionPathExists=%s
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+%s
+
+[Install]
+WantedBy=multi-user.target
+`
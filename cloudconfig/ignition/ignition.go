@@ -0,0 +1,242 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package ignition builds Ignition v3 configs, which are used instead of cloud-init by
+// Flatcar Container Linux and Fedora CoreOS.
+package ignition
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Version is the version of the Ignition spec generated by this package. It is supported
+// by both Flatcar and Fedora CoreOS.
+const Version = "3.3.0"
+
+// NewConfig returns an empty Ignition config.
+func NewConfig() *Config {
+	return &Config{
+		Ignition: Ignition{
+			Version: Version,
+		},
+	}
+}
+
+type Ignition struct {
+	Version string `json:"version"`
+}
+
+// Resource is the contents of a file. Inline contents are encoded as a data URL.
+type Resource struct {
+	Source string `json:"source"`
+}
+
+type NodeUser struct {
+	Name string `json:"name"`
+}
+
+type NodeGroup struct {
+	Name string `json:"name"`
+}
+
+type File struct {
+	Path      string     `json:"path"`
+	Overwrite bool       `json:"overwrite"`
+	Mode      int        `json:"mode"`
+	Contents  Resource   `json:"contents"`
+	User      *NodeUser  `json:"user,omitempty"`
+	Group     *NodeGroup `json:"group,omitempty"`
+}
+
+type Storage struct {
+	Files []File `json:"files,omitempty"`
+}
+
+type User struct {
+	Name              string   `json:"name"`
+	HomeDir           string   `json:"homeDir,omitempty"`
+	Shell             string   `json:"shell,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+}
+
+type Passwd struct {
+	Users []User `json:"users,omitempty"`
+}
+
+type Unit struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Contents string `json:"contents"`
+}
+
+type Systemd struct {
+	Units []Unit `json:"units,omitempty"`
+}
+
+// Config is an Ignition v3 config. Use the Add* methods to build it.
+type Config struct {
+	mux sync.Mutex
+
+	Ignition Ignition `json:"ignition"`
+	Passwd   Passwd   `json:"passwd"`
+	Storage  Storage  `json:"storage"`
+	Systemd  Systemd  `json:"systemd"`
+}
+
+func (c *Config) findUser(name string) *User {
+	for i := range c.Passwd.Users {
+		if c.Passwd.Users[i].Name == name {
+			return &c.Passwd.Users[i]
+		}
+	}
+	return nil
+}
+
+// AddUser adds a user to the config. If the user already exists, the missing groups
+// are added to it.
+func (c *Config) AddUser(name, homeDir, shell string, groups ...string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	user := c.findUser(name)
+	if user == nil {
+		c.Passwd.Users = append(c.Passwd.Users, User{
+			Name:    name,
+			HomeDir: homeDir,
+			Shell:   shell,
+		})
+		user = &c.Passwd.Users[len(c.Passwd.Users)-1]
+	}
+	user.Groups = appendMissing(user.Groups, groups...)
+}
+
+// AddSSHKey adds SSH public keys to the authorized keys of a user. The user is created
+// if it does not exist.
+func (c *Config) AddSSHKey(userName string, keys ...string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	user := c.findUser(userName)
+	if user == nil {
+		c.Passwd.Users = append(c.Passwd.Users, User{
+			Name: userName,
+		})
+		user = &c.Passwd.Users[len(c.Passwd.Users)-1]
+	}
+	user.SSHAuthorizedKeys = appendMissing(user.SSHAuthorizedKeys, keys...)
+}
+
+// AddFile adds a file to the config. The owner is in the user:group format. Files are
+// unique by path. Adding a file to a path that is already used is a no-op.
+func (c *Config) AddFile(contents []byte, path, owner string, mode int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, val := range c.Storage.Files {
+		if val.Path == path {
+			return
+		}
+	}
+
+	file := File{
+		Path:      path,
+		Overwrite: true,
+		Mode:      mode,
+		Contents: Resource{
+			Source: fmt.Sprintf("data:;base64,%s", base64.StdEncoding.EncodeToString(contents)),
+		},
+	}
+	if owner != "" {
+		userName, groupName, _ := strings.Cut(owner, ":")
+		if userName != "" {
+			file.User = &NodeUser{Name: userName}
+		}
+		if groupName != "" {
+			file.Group = &NodeGroup{Name: groupName}
+		}
+	}
+	c.Storage.Files = append(c.Storage.Files, file)
+}
+
+// AddCACert validates the PEM encoded CA bundle and writes it to the given path. Where
+// the bundle needs to be written to be trusted depends on the distro.
+func (c *Config) AddCACert(cert []byte, path string) error {
+	if cert == nil {
+		return nil
+	}
+
+	roots := x509.NewCertPool()
+	if ok := roots.AppendCertsFromPEM(cert); !ok {
+		return fmt.Errorf("failed to parse CA cert bundle")
+	}
+	c.AddFile(cert, path, "root:root", 0o644)
+
+	return nil
+}
+
+// AddUnit adds a systemd unit to the config. Units are unique by name. Adding a unit
+// with a name that is already used is a no-op.
+func (c *Config) AddUnit(name, contents string, enabled bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, val := range c.Systemd.Units {
+		if val.Name == name {
+			return
+		}
+	}
+
+	c.Systemd.Units = append(c.Systemd.Units, Unit{
+		Name:     name,
+		Enabled:  enabled,
+		Contents: contents,
+	})
+}
+
+// Serialize returns the JSON encoded config.
+func (c *Config) Serialize() (string, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	asJs, err := json.Marshal(c)
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling to json")
+	}
+
+	return string(asJs), nil
+}
+
+func appendMissing(values []string, newValues ...string) []string {
+	for _, newValue := range newValues {
+		found := false
+		for _, val := range values {
+			if val == newValue {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, newValue)
+		}
+	}
+	return values
+}
@@ -0,0 +1,63 @@
+package ignition
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	cfg := NewConfig()
+	cfg.AddUser("runner", "/home/runner", "/bin/bash", "docker")
+	cfg.AddUser("runner", "", "", "docker", "wheel")
+	cfg.AddSSHKey("runner", "ssh-rsa key1", "ssh-rsa key1", "ssh-rsa key2")
+	cfg.AddFile([]byte("hello"), "/etc/hello", "root:wheel", 0o644)
+	cfg.AddFile([]byte("ignored"), "/etc/hello", "root:root", 0o600)
+	cfg.AddUnit("test.service", "[Unit]\n", true)
+	cfg.AddUnit("test.service", "ignored", false)
+
+	asStr, err := cfg.Serialize()
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"ignition": {"version": "3.3.0"},
+		"passwd": {
+			"users": [{
+				"name": "runner",
+				"homeDir": "/home/runner",
+				"shell": "/bin/bash",
+				"groups": ["docker", "wheel"],
+				"sshAuthorizedKeys": ["ssh-rsa key1", "ssh-rsa key2"]
+			}]
+		},
+		"storage": {
+			"files": [{
+				"path": "/etc/hello",
+				"overwrite": true,
+				"mode": 420,
+				"contents": {"source": "data:;base64,aGVsbG8="},
+				"user": {"name": "root"},
+				"group": {"name": "wheel"}
+			}]
+		},
+		"systemd": {
+			"units": [{"name": "test.service", "enabled": true, "contents": "[Unit]\n"}]
+		}
+	}`, asStr)
+}
+
+func TestAddCACert(t *testing.T) {
+	cfg := NewConfig()
+	require.NoError(t, cfg.AddCACert(nil, "/etc/ssl/certs/ca.pem"))
+	require.EqualError(t, cfg.AddCACert([]byte("bogus"), "/etc/ssl/certs/ca.pem"), "failed to parse CA cert bundle")
+	require.Empty(t, cfg.Storage.Files)
+}
+
+func TestSerializeEmpty(t *testing.T) {
+	asStr, err := NewConfig().Serialize()
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(asStr), &decoded))
+	require.Equal(t, map[string]interface{}{"version": "3.3.0"}, decoded["ignition"])
+}
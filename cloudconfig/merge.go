@@ -0,0 +1,280 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package cloudconfig
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// prependCloudConfigKeys are the lists whose order is protected. The user supplied
+// items are added before ours, so the runner is still installed last.
+var prependCloudConfigKeys = map[string]bool{
+	"runcmd": true,
+}
+
+// guardedCloudConfigKeys are the top level keys the runner install relies on. Within
+// them, the values we generate can't be removed (set to null) or replaced by a value
+// of a different type. Lists can still be extended.
+var guardedCloudConfigKeys = map[string]bool{
+	"write_files": true,
+	"runcmd":      true,
+	"bootcmd":     true,
+	"users":       true,
+	"system_info": true,
+	"ca-certs":    true,
+}
+
+// protectedCloudConfigKeys are the subtrees whose generated scalars can't be changed by
+// a user supplied cloud-config. The install script relies on the default user we define.
+var protectedCloudConfigKeys = map[string]bool{
+	"system_info": true,
+}
+
+// cloudConfigRoot returns the top level key of a path.
+func cloudConfigRoot(path string) string {
+	root, _, _ := strings.Cut(path, ".")
+	return root
+}
+
+// cloudConfigKind returns the kind of a generic yaml value: map, list or scalar.
+func cloudConfigKind(val interface{}) string {
+	switch val.(type) {
+	case map[string]interface{}:
+		return "map"
+	case []interface{}:
+		return "list"
+	}
+	return "scalar"
+}
+
+// shellSafeArg matches the arguments that don't need to be quoted.
+var shellSafeArg = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes an argument, so the shell passes it as is to the command.
+func shellQuote(arg string) string {
+	if shellSafeArg.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// runCmdToShell converts the list-form items of runcmd (eg: ["echo", "hi"]) to the
+// equivalent shell commands, as CloudInit.RunCmd only holds strings.
+func runCmdToShell(items []interface{}) ([]interface{}, error) {
+	ret := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		args, ok := item.([]interface{})
+		if !ok {
+			if _, isString := item.(string); !isString {
+				return nil, fmt.Errorf("cloud config key runcmd must hold strings or lists of strings, got %v", item)
+			}
+			ret = append(ret, item)
+			continue
+		}
+
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			if cloudConfigKind(arg) != "scalar" || arg == nil {
+				return nil, fmt.Errorf("cloud config key runcmd must hold strings or lists of strings, got %v", item)
+			}
+			quoted[i] = shellQuote(fmt.Sprint(arg))
+		}
+		ret = append(ret, strings.Join(quoted, " "))
+	}
+	return ret, nil
+}
+
+// checkGuardedOverride makes sure an override doesn't remove a generated value, or
+// replace it with a value of a different type.
+func checkGuardedOverride(path string, base, override interface{}) error {
+	if base == nil || !guardedCloudConfigKeys[cloudConfigRoot(path)] {
+		return nil
+	}
+	if override == nil {
+		return fmt.Errorf("cloud config key %s can not be removed", path)
+	}
+	if baseKind, overrideKind := cloudConfigKind(base), cloudConfigKind(override); baseKind != overrideKind {
+		return fmt.Errorf("cloud config key %s must be a %s, got a %s", path, baseKind, overrideKind)
+	}
+	return nil
+}
+
+// Merge deep merges a partial cloud-config document into the config. Maps are merged
+// recursively, lists are appended to and deduplicated, and scalars from the document
+// replace ours. Items added to runcmd are run before ours, and list-form items are
+// converted to shell commands. The values we generate for the keys the runner install
+// relies on (eg: write_files, runcmd, system_info) can't be removed or replaced. Keys
+// that are not modelled by CloudInit are kept in Extra.
+func (c *CloudInit) Merge(doc map[string]interface{}) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if len(doc) == 0 {
+		return nil
+	}
+
+	base, err := toYAMLMap(c)
+	if err != nil {
+		return errors.Wrap(err, "converting cloud config")
+	}
+	override, err := toYAMLMap(doc)
+	if err != nil {
+		return errors.Wrap(err, "converting user cloud config")
+	}
+
+	merged, err := mergeCloudConfig("", base, override)
+	if err != nil {
+		return err
+	}
+
+	asYaml, err := yaml.Marshal(merged)
+	if err != nil {
+		return errors.Wrap(err, "marshaling merged cloud config")
+	}
+	var ret CloudInit
+	if err := yaml.Unmarshal(asYaml, &ret); err != nil {
+		return errors.Wrap(err, "unmarshaling merged cloud config")
+	}
+
+	// Copy the exported fields one by one, to avoid copying the mutex.
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(&ret).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if dst.Type().Field(i).IsExported() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	c.ensureDefaultUser()
+	return nil
+}
+
+// toYAMLMap converts v to the generic map yaml uses, so values from different sources
+// (eg: JSON numbers and yaml integers) compare equal.
+func toYAMLMap(v interface{}) (map[string]interface{}, error) {
+	asYaml, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]interface{}{}
+	if err := yaml.Unmarshal(asYaml, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func mergeCloudConfig(path string, base, override interface{}) (interface{}, error) {
+	if err := checkGuardedOverride(path, base, override); err != nil {
+		return nil, err
+	}
+
+	switch overrideVal := override.(type) {
+	case map[string]interface{}:
+		baseMap, ok := base.(map[string]interface{})
+		if !ok {
+			return override, nil
+		}
+		for key, val := range overrideVal {
+			keyPath := key
+			if path != "" {
+				keyPath = fmt.Sprintf("%s.%s", path, key)
+			}
+			merged, err := mergeCloudConfig(keyPath, baseMap[key], val)
+			if err != nil {
+				return nil, err
+			}
+			baseMap[key] = merged
+		}
+		return baseMap, nil
+	case []interface{}:
+		if path == "runcmd" {
+			converted, err := runCmdToShell(overrideVal)
+			if err != nil {
+				return nil, err
+			}
+			overrideVal = converted
+		}
+		baseList, ok := base.([]interface{})
+		if !ok {
+			return overrideVal, nil
+		}
+		if path == "write_files" {
+			if err := checkWriteFiles(baseList, overrideVal); err != nil {
+				return nil, err
+			}
+		}
+		if prependCloudConfigKeys[path] {
+			var prepended []interface{}
+			for _, item := range overrideVal {
+				if !containsItem(baseList, item) && !containsItem(prepended, item) {
+					prepended = append(prepended, item)
+				}
+			}
+			return append(prepended, baseList...), nil
+		}
+		return appendMissing(baseList, overrideVal), nil
+	default:
+		if base != nil && protectedCloudConfigKeys[cloudConfigRoot(path)] && !reflect.DeepEqual(base, override) {
+			return nil, fmt.Errorf("cloud config key %s can not be changed", path)
+		}
+		return override, nil
+	}
+}
+
+// appendMissing appends the items that are not already in the list.
+func appendMissing(list []interface{}, items []interface{}) []interface{} {
+	for _, item := range items {
+		if !containsItem(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// checkWriteFiles makes sure the user supplied files don't overwrite the files we write,
+// like the install script.
+func checkWriteFiles(base, override []interface{}) error {
+	paths := map[interface{}]bool{}
+	for _, file := range base {
+		if asMap, ok := file.(map[string]interface{}); ok {
+			paths[asMap["path"]] = true
+		}
+	}
+	for _, file := range override {
+		asMap, ok := file.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if paths[asMap["path"]] && !containsItem(base, file) {
+			return fmt.Errorf("cloud config file %v can not be overwritten", asMap["path"])
+		}
+	}
+	return nil
+}
+
+func containsItem(list []interface{}, item interface{}) bool {
+	for _, val := range list {
+		if reflect.DeepEqual(val, item) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package cloudconfig
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func decodeCloudConfigDoc(t *testing.T, doc string) map[string]interface{} {
+	ret := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(doc), &ret))
+	return ret
+}
+
+func TestMerge(t *testing.T) {
+	cfg := NewDefaultCloudInitConfig()
+	cfg.AddRunCmd("first")
+	cfg.AddRunCmd("second")
+	cfg.AddSSHKey("ssh-rsa ours")
+
+	err := cfg.Merge(decodeCloudConfigDoc(t, `{
+		"package_upgrade": false,
+		"packages": ["curl", "jq"],
+		"ssh_authorized_keys": ["ssh-rsa theirs"],
+		"runcmd": ["user", "second"],
+		"bootcmd": [["echo", "early"]],
+		"mounts": [["/dev/sdb", "/data", "ext4", "defaults", "0", "2"]],
+		"apt": {"sources": {"test": {"source": "deb http://example.com focal main"}}},
+		"snap": {"commands": ["snap install jq"]},
+		"system_info": {"default_user": {"name": "runner"}}
+	}`))
+	require.NoError(t, err)
+
+	require.False(t, cfg.PackageUpgrade)
+	require.Equal(t, []string{"curl", "tar", "jq"}, cfg.Packages)
+	require.Equal(t, []string{"ssh-rsa ours", "ssh-rsa theirs"}, cfg.SSHAuthorizedKeys)
+	require.Equal(t, []string{"user", "first", "second"}, cfg.RunCmd)
+	require.Equal(t, "runner", cfg.SystemInfo.DefaultUser.Name)
+	require.Equal(t, []Command{{Args: []string{"echo", "early"}}}, cfg.BootCmd)
+	require.Equal(t, [][]string{{"/dev/sdb", "/data", "ext4", "defaults", "0", "2"}}, cfg.Mounts)
+	require.Equal(t, "deb http://example.com focal main", cfg.Apt.Sources["test"].Source)
+	require.Equal(t, map[string]interface{}{"snap": map[string]interface{}{"commands": []interface{}{"snap install jq"}}}, cfg.Extra)
+
+	asStr, err := cfg.Serialize()
+	require.NoError(t, err)
+
+	var roundTrip CloudInit
+	require.NoError(t, yaml.Unmarshal([]byte(asStr), &roundTrip))
+	require.Equal(t, cfg.Extra, roundTrip.Extra)
+	require.Equal(t, cfg.RunCmd, roundTrip.RunCmd)
+}
+
+func TestMergeKeepsDefaultUser(t *testing.T) {
+	cfg := NewDefaultCloudInitConfig()
+	require.NoError(t, cfg.Merge(decodeCloudConfigDoc(t, `{"users": [{"name": "admin", "groups": ["wheel"]}]}`)))
+	require.Equal(t, []User{{Name: DefaultUserEntry}, {Name: "admin", Groups: []string{"wheel"}}}, cfg.Users)
+
+	asStr, err := cfg.Serialize()
+	require.NoError(t, err)
+	require.Contains(t, asStr, "users:\n    - default\n    - name: admin\n")
+}
+
+func TestMergeProtectedKeys(t *testing.T) {
+	cfg := NewDefaultCloudInitConfig()
+	err := cfg.Merge(decodeCloudConfigDoc(t, `{"system_info": {"default_user": {"name": "admin"}}}`))
+	require.EqualError(t, err, "cloud config key system_info.default_user.name can not be changed")
+	err = cfg.Merge(decodeCloudConfigDoc(t, `{"system_info": {"default_user": {"shell": "/bin/zsh"}}}`))
+	require.EqualError(t, err, "cloud config key system_info.default_user.shell can not be changed")
+
+	cfg.AddFile([]byte("ours"), "/install_runner.sh", "root:root", "755")
+	err = cfg.Merge(decodeCloudConfigDoc(t, `{"write_files": [{"path": "/install_runner.sh", "content": "theirs"}]}`))
+	require.EqualError(t, err, "cloud config file /install_runner.sh can not be overwritten")
+
+	require.NoError(t, cfg.Merge(decodeCloudConfigDoc(t, `{"write_files": [{"path": "/etc/motd", "content": "hello"}]}`)))
+	require.Len(t, cfg.WriteFiles, 2)
+	require.Equal(t, "/etc/motd", cfg.WriteFiles[1].Path)
+}
+
+func TestGetCloudInitConfigMergesExtraSpecs(t *testing.T) {
+	linuxParams := params.BootstrapInstance{
+		OSType:     params.Linux,
+		ExtraSpecs: []byte(`{"cloud_config": {"bootcmd": ["echo early"], "runcmd": ["echo user"]}}`),
+	}
+
+	cfg, err := GetCloudInitConfig(linuxParams, []byte("script"))
+	require.NoError(t, err)
+
+	var decoded CloudInit
+	require.NoError(t, yaml.Unmarshal([]byte(cfg), &decoded))
+	require.Equal(t, []Command{{Shell: "echo early"}}, decoded.BootCmd)
+	require.Equal(t, "echo user", decoded.RunCmd[0])
+	require.Equal(t, "rm -f /install_runner.sh", decoded.RunCmd[len(decoded.RunCmd)-1])
+}
+
+func TestMergeRejectsRemovingGeneratedKeys(t *testing.T) {
+	for _, doc := range []string{
+		`{"write_files": null}`,
+		`{"runcmd": null}`,
+		`{"system_info": null}`,
+		`{"system_info": {"default_user": null}}`,
+		`{"users": null}`,
+		`{"bootcmd": null}`,
+	} {
+		cfg := NewDefaultCloudInitConfig()
+		cfg.AddFile([]byte("ours"), "/install_runner.sh", "root:root", "755")
+		cfg.AddRunCmd("/install_runner.sh")
+		cfg.AddBootCmd("echo early")
+		cfg.AddUser(User{Name: "admin"})
+
+		err := cfg.Merge(decodeCloudConfigDoc(t, doc))
+		require.ErrorContains(t, err, "can not be removed", doc)
+	}
+}
+
+func TestMergeRejectsTypeChanges(t *testing.T) {
+	cfg := NewDefaultCloudInitConfig()
+	cfg.AddFile([]byte("ours"), "/install_runner.sh", "root:root", "755")
+	cfg.AddRunCmd("/install_runner.sh")
+
+	err := cfg.Merge(decodeCloudConfigDoc(t, `{"runcmd": "echo replaced"}`))
+	require.EqualError(t, err, "cloud config key runcmd must be a list, got a scalar")
+	err = cfg.Merge(decodeCloudConfigDoc(t, `{"write_files": {"path": "/etc/motd"}}`))
+	require.EqualError(t, err, "cloud config key write_files must be a list, got a map")
+	err = cfg.Merge(decodeCloudConfigDoc(t, `{"system_info": ["default_user"]}`))
+	require.EqualError(t, err, "cloud config key system_info must be a map, got a list")
+
+	require.Equal(t, []string{"/install_runner.sh"}, cfg.RunCmd)
+	require.Len(t, cfg.WriteFiles, 1)
+}
+
+func TestMergeListFormCommands(t *testing.T) {
+	cfg := NewDefaultCloudInitConfig()
+	cfg.AddRunCmd("/install_runner.sh")
+
+	err := cfg.Merge(decodeCloudConfigDoc(t, `{"runcmd": [["echo", "x"], ["sh", "-c", "echo it's $HOME"], "echo y"], "bootcmd": [["echo", "early"]]}`))
+	require.NoError(t, err)
+	// RunCmd only holds strings, so list-form items are converted to shell commands.
+	require.Equal(t, []string{
+		"echo x",
+		`sh -c 'echo it'\''s $HOME'`,
+		"echo y",
+		"/install_runner.sh",
+	}, cfg.RunCmd)
+	require.Equal(t, []Command{{Args: []string{"echo", "early"}}}, cfg.BootCmd)
+
+	err = cfg.Merge(decodeCloudConfigDoc(t, `{"runcmd": [{"cmd": "echo x"}]}`))
+	require.EqualError(t, err, "cloud config key runcmd must hold strings or lists of strings, got map[cmd:echo x]")
+}
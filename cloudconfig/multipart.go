@@ -0,0 +1,202 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package cloudconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// PartContentType is the content type of a part of a multipart userdata. It tells
+// cloud-init how to handle the part.
+type PartContentType string
+
+const (
+	// PartCloudConfig is a cloud-config document.
+	PartCloudConfig PartContentType = "text/cloud-config"
+	// PartShellScript is a script that is run once, on first boot.
+	PartShellScript PartContentType = "text/x-shellscript"
+	// PartBoothook is a script that is run early, on every boot.
+	PartBoothook PartContentType = "text/cloud-boothook"
+	// PartIncludeURL is a list of URLs, one per line, that are downloaded and handled as
+	// userdata.
+	PartIncludeURL PartContentType = "text/x-include-url"
+)
+
+// IsValid returns true if cloud-init knows how to handle the content type.
+func (p PartContentType) IsValid() bool {
+	switch p {
+	case PartCloudConfig, PartShellScript, PartBoothook, PartIncludeURL:
+		return true
+	}
+	return false
+}
+
+// MergeTypeAppend makes cloud-init append the lists of a cloud-config part to the
+// previous parts, instead of replacing them. Keys that were already set are kept.
+const MergeTypeAppend = "list(append)+dict(no_replace,recurse_list)+str()"
+
+// UserDataPart is a part of a multipart userdata.
+type UserDataPart struct {
+	// ContentType is the content type of the part.
+	ContentType PartContentType `json:"content_type"`
+	// Filename is the name of the part. It is optional, but makes debugging easier, as
+	// cloud-init uses it when saving the part on disk.
+	Filename string `json:"filename,omitempty"`
+	// Content is the content of the part.
+	Content []byte `json:"content"`
+	// MergeType is the cloud-init merge type of a cloud-config part. If empty, cloud-init
+	// uses its default, which replaces the keys set by the previous parts.
+	MergeType string `json:"merge_type,omitempty"`
+}
+
+// MultipartUserData composes several userdata parts into a single MIME multipart
+// document, which cloud-init handles one part at a time.
+type MultipartUserData struct {
+	mux sync.Mutex
+
+	parts []UserDataPart
+}
+
+// NewMultipartUserData returns an empty multipart userdata.
+func NewMultipartUserData() *MultipartUserData {
+	return &MultipartUserData{}
+}
+
+// AddPart adds a part to the userdata. Parts are handled by cloud-init in the order in
+// which they are added.
+func (m *MultipartUserData) AddPart(part UserDataPart) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if !part.ContentType.IsValid() {
+		return fmt.Errorf("unsupported content type: %q", part.ContentType)
+	}
+	if part.MergeType != "" && part.ContentType != PartCloudConfig {
+		return fmt.Errorf("merge type is only supported on %s parts", PartCloudConfig)
+	}
+
+	m.parts = append(m.parts, part)
+	return nil
+}
+
+// AddCloudConfig serializes the cloud-init config and adds it as a cloud-config part.
+func (m *MultipartUserData) AddCloudConfig(cfg *CloudInit, filename string) error {
+	asStr, err := cfg.Serialize()
+	if err != nil {
+		return errors.Wrap(err, "serializing cloud config")
+	}
+
+	return m.AddPart(UserDataPart{
+		ContentType: PartCloudConfig,
+		Filename:    filename,
+		Content:     []byte(asStr),
+	})
+}
+
+// AddShellScript adds a script that is run once, on first boot.
+func (m *MultipartUserData) AddShellScript(script []byte, filename string) error {
+	return m.AddPart(UserDataPart{
+		ContentType: PartShellScript,
+		Filename:    filename,
+		Content:     script,
+	})
+}
+
+// Serialize returns the MIME multipart document. The content of each part is base64
+// encoded, so scripts and binary payloads are passed through unaltered.
+func (m *MultipartUserData) Serialize() (string, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if len(m.parts) == 0 {
+		return "", fmt.Errorf("no userdata parts")
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=\"%s\"\r\n", writer.Boundary())
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n\r\n")
+
+	for _, part := range m.parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", part.ContentType))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Transfer-Encoding", "base64")
+		if part.Filename != "" {
+			header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", part.Filename))
+		}
+		if part.MergeType != "" {
+			header.Set("Merge-Type", part.MergeType)
+		}
+
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return "", errors.Wrap(err, "creating part")
+		}
+		if _, err := partWriter.Write(wrapBase64(part.Content)); err != nil {
+			return "", errors.Wrap(err, "writing part")
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", errors.Wrap(err, "closing multipart writer")
+	}
+
+	return buf.String(), nil
+}
+
+// SerializeGzip returns the gzip compressed MIME multipart document. cloud-init detects
+// and decompresses gzip userdata, which helps staying under the userdata size limit of
+// most clouds.
+func (m *MultipartUserData) SerializeGzip() ([]byte, error) {
+	asStr, err := m.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	if _, err := gzWriter.Write([]byte(asStr)); err != nil {
+		return nil, errors.Wrap(err, "compressing userdata")
+	}
+	if err := gzWriter.Close(); err != nil {
+		return nil, errors.Wrap(err, "compressing userdata")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// wrapBase64 encodes data as base64, wrapped at 76 characters per line as MIME requires.
+func wrapBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,137 @@
+package cloudconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+type decodedPart struct {
+	header  map[string]string
+	content string
+}
+
+func decodeMultipart(t *testing.T, userData string) []decodedPart {
+	msg, err := mail.ReadMessage(strings.NewReader(userData))
+	require.NoError(t, err)
+	require.Equal(t, "1.0", msg.Header.Get("MIME-Version"))
+
+	mediaType, mediaParams, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/mixed", mediaType)
+
+	var parts []decodedPart
+	reader := multipart.NewReader(msg.Body, mediaParams["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		encoded, err := io.ReadAll(part)
+		require.NoError(t, err)
+		content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(encoded)))
+		require.NoError(t, err)
+
+		header := map[string]string{}
+		for key := range part.Header {
+			header[key] = part.Header.Get(key)
+		}
+		parts = append(parts, decodedPart{header: header, content: string(content)})
+	}
+	return parts
+}
+
+func TestMultipartUserData(t *testing.T) {
+	cfg := NewDefaultCloudInitConfig()
+	cfg.AddRunCmd("echo hello")
+	longScript := []byte("#!/bin/bash\n" + strings.Repeat("echo 0123456789\n", 20))
+
+	userData := NewMultipartUserData()
+	require.NoError(t, userData.AddCloudConfig(cfg, "cloud-config.yaml"))
+	require.NoError(t, userData.AddShellScript(longScript, "script.sh"))
+	require.NoError(t, userData.AddPart(UserDataPart{
+		ContentType: PartBoothook,
+		Content:     []byte("#cloud-boothook\necho early"),
+	}))
+
+	asStr, err := userData.Serialize()
+	require.NoError(t, err)
+
+	parts := decodeMultipart(t, asStr)
+	require.Len(t, parts, 3)
+
+	require.Equal(t, `text/cloud-config; charset="utf-8"`, parts[0].header["Content-Type"])
+	require.Equal(t, `attachment; filename="cloud-config.yaml"`, parts[0].header["Content-Disposition"])
+	require.True(t, strings.HasPrefix(parts[0].content, "#cloud-config\n"))
+	require.Contains(t, parts[0].content, "echo hello")
+
+	require.Equal(t, `text/x-shellscript; charset="utf-8"`, parts[1].header["Content-Type"])
+	require.Equal(t, string(longScript), parts[1].content)
+
+	require.Equal(t, `text/cloud-boothook; charset="utf-8"`, parts[2].header["Content-Type"])
+	require.NotContains(t, parts[2].header, "Content-Disposition")
+}
+
+func TestMultipartUserDataGzip(t *testing.T) {
+	userData := NewMultipartUserData()
+	require.NoError(t, userData.AddShellScript([]byte("#!/bin/bash\necho hello"), "script.sh"))
+
+	asStr, err := userData.Serialize()
+	require.NoError(t, err)
+	compressed, err := userData.SerializeGzip()
+	require.NoError(t, err)
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	// The boundary is random, so only compare the parts.
+	require.Equal(t, decodeMultipart(t, asStr), decodeMultipart(t, string(decompressed)))
+}
+
+func TestMultipartUserDataInvalidParts(t *testing.T) {
+	userData := NewMultipartUserData()
+	require.EqualError(t, userData.AddPart(UserDataPart{ContentType: "text/plain"}), `unsupported content type: "text/plain"`)
+	require.EqualError(t, userData.AddPart(UserDataPart{ContentType: PartShellScript, MergeType: MergeTypeAppend}), "merge type is only supported on text/cloud-config parts")
+
+	_, err := userData.Serialize()
+	require.EqualError(t, err, "no userdata parts")
+}
+
+func TestGetCloudConfigWithUserDataParts(t *testing.T) {
+	linuxParams := params.BootstrapInstance{
+		OSType: params.Linux,
+		ExtraSpecs: []byte(`{"userdata_parts": [
+			{"content_type": "text/cloud-config", "filename": "extra.yaml", "content": "I2Nsb3VkLWNvbmZpZwpwYWNrYWdlczogW2pxXQo="},
+			{"content_type": "text/x-include-url", "content": "aHR0cHM6Ly9leGFtcGxlLmNvbS91c2VyZGF0YQ=="}
+		]}`),
+	}
+
+	userData, err := GetCloudConfig(linuxParams, tools, "test-runner-name")
+	require.NoError(t, err)
+
+	parts := decodeMultipart(t, userData)
+	require.Len(t, parts, 3)
+	require.Equal(t, `attachment; filename="garm-cloud-config.yaml"`, parts[0].header["Content-Disposition"])
+	require.Contains(t, parts[0].content, "/install_runner.sh")
+	require.Equal(t, "#cloud-config\npackages: [jq]\n", parts[1].content)
+	require.Equal(t, MergeTypeAppend, parts[1].header["Merge-Type"])
+	require.Equal(t, "https://example.com/userdata", parts[2].content)
+	require.NotContains(t, parts[2].header, "Merge-Type")
+
+	linuxParams.ExtraSpecs = []byte(`{"userdata_parts": [{"content_type": "text/plain", "content": ""}]}`)
+	_, err = GetCloudConfig(linuxParams, tools, "test-runner-name")
+	require.ErrorContains(t, err, `unsupported content type: "text/plain"`)
+}
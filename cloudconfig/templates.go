@@ -105,6 +105,10 @@ function downloadAndExtractRunner() {
 	TEMP_TOKEN="Authorization: Bearer {{ .TempDownloadToken }}"
 	fi
 	curl --retry 5 --retry-delay 5 --retry-connrefused --fail -L -H "${TEMP_TOKEN}" -o "/home/{{ .RunnerUsername }}/{{ .FileName }}" "{{ .DownloadURL }}" || fail "failed to download tools"
+	{{- if .SHA256Checksum }}
+	sendStatus "verifying runner checksum"
+	echo "{{ .SHA256Checksum }}  /home/{{ .RunnerUsername }}/{{ .FileName }}" | sha256sum -c - || fail "runner checksum mismatch"
+	{{- end }}
 	mkdir -p /home/{{ .RunnerUsername }}/actions-runner || fail "failed to create actions-runner folder"
 	sendStatus "extracting runner"
 	tar xf "/home/{{ .RunnerUsername }}/{{ .FileName }}" -C /home/{{ .RunnerUsername }}/actions-runner/ || fail "failed to extract runner"
@@ -418,6 +422,14 @@ function Install-Runner() {
 		}
 		$downloadPath = Join-Path $env:TMP {{.FileName}}
 		Invoke-FastWebRequest -Uri $DownloadURL -OutFile $downloadPath -Headers $DownloadTokenHeaders
+		{{- if .SHA256Checksum }}
+
+		Update-GarmStatus -CallbackURL $CallbackURL -Message "verifying runner checksum"
+		$downloadHash = (Get-FileHash -Path $downloadPath -Algorithm SHA256).Hash
+		if ($downloadHash -ne "{{.SHA256Checksum}}") {
+			Throw "runner checksum mismatch: expected {{.SHA256Checksum}}, got $downloadHash"
+		}
+		{{- end }}
 
 		$runnerDir = "C:\runner"
 		mkdir $runnerDir
@@ -511,6 +523,9 @@ type InstallRunnerParams struct {
 	ExtraContext map[string]string
 	// UseJITConfig indicates whether to attempt to configure the runner using JIT or a registration token.
 	UseJITConfig bool
+	// SHA256Checksum is the expected SHA256 checksum of the runner archive. If set, the downloaded
+	// archive is verified and the install fails if the checksum does not match.
+	SHA256Checksum string
 }
 
 func InstallRunnerScript(installParams InstallRunnerParams, osType params.OSType, tpl string) ([]byte, error) {
@@ -15,16 +15,22 @@
 package cloudconfig
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
 
 	"github.com/cloudbase/garm-provider-common/defaults"
+	"github.com/cloudbase/garm-provider-common/extraspecs"
 	"github.com/cloudbase/garm-provider-common/params"
 	"github.com/pkg/errors"
 )
 
+// CloudConfigSpecName is the name under which CloudConfigSpec is registered in an
+// extra specs registry.
+const CloudConfigSpecName = "cloudconfig"
+
 // CloudConfigSpec is a struct that holds extra specs that can be used to customize user data.
 type CloudConfigSpec struct {
 	// RunnerInstallTemplate can be used to override the default runner install template.
@@ -47,6 +53,19 @@ type CloudConfigSpec struct {
 	PreInstallScripts map[string][]byte `json:"pre_install_scripts"`
 	// ExtraContext is a map of extra context that will be passed to the runner install template.
 	ExtraContext map[string]string `json:"extra_context"`
+	// Distro is the Linux distribution of the image (eg: flatcar, fedora-coreos). Distros
+	// that don't use cloud-init get an Ignition config from GetCloudConfig instead of a
+	// cloud-init config.
+	Distro string `json:"distro"`
+	// UserDataParts are extra userdata parts (eg: a cloud-config, a boothook or an include)
+	// that are appended to the cloud-init config we generate. If set, the userdata is a MIME
+	// multipart document. Cloud-config parts without a merge type get MergeTypeAppend, so
+	// they extend our cloud-init config instead of replacing it.
+	UserDataParts []UserDataPart `json:"userdata_parts"`
+	// CloudConfig is a partial cloud-config document (eg: bootcmd, mounts, apt sources) that
+	// is merged into the cloud-init config generated by GetCloudInitConfig(). See CloudInit.Merge()
+	// for the merge semantics.
+	CloudConfig map[string]interface{} `json:"cloud_config"`
 }
 
 func sortMapKeys(m map[string][]byte) []string {
@@ -81,6 +100,13 @@ func GetSpecs(bootstrapParams params.BootstrapInstance) (CloudConfigSpec, error)
 	return extraSpecs, nil
 }
 
+// RegisterSpecs registers CloudConfigSpec in the extra specs registry of a provider,
+// under the name CloudConfigSpecName. Providers that use this package to generate
+// userdata should call this, so the userdata keys are not reported as unknown.
+func RegisterSpecs(registry *extraspecs.Registry) error {
+	return registry.Register(CloudConfigSpecName, CloudConfigSpec{})
+}
+
 // GetRunnerInstallScript returns the runner install script for the given bootstrap params.
 // This function will return either the default script for the given OS type or will use the supplied template
 // if one is provided.
@@ -93,6 +119,13 @@ func GetRunnerInstallScript(bootstrapParams params.BootstrapInstance, tools para
 		return nil, fmt.Errorf("missing tools download URL")
 	}
 
+	// The checksum is rendered into the script, so anything but a SHA256 hex digest is refused.
+	if checksum := tools.GetSHA256Checksum(); checksum != "" {
+		if _, err := hex.DecodeString(checksum); err != nil || len(checksum) != 64 {
+			return nil, fmt.Errorf("invalid tools SHA256 checksum: %q", checksum)
+		}
+	}
+
 	tempToken := tools.GetTempDownloadToken()
 	extraSpecs, err := GetSpecs(bootstrapParams)
 	if err != nil {
@@ -115,6 +148,7 @@ func GetRunnerInstallScript(bootstrapParams params.BootstrapInstance, tools para
 		ExtraContext:      extraSpecs.ExtraContext,
 		EnableBootDebug:   bootstrapParams.UserDataOptions.EnableBootDebug,
 		UseJITConfig:      bootstrapParams.JitConfigEnabled,
+		SHA256Checksum:    tools.GetSHA256Checksum(),
 	}
 
 	if bootstrapParams.CACertBundle != nil && len(bootstrapParams.CACertBundle) > 0 {
@@ -168,6 +202,10 @@ func GetCloudInitConfig(bootstrapParams params.BootstrapInstance, installScript
 		}
 	}
 
+	if err := cloudCfg.Merge(extraSpecs.CloudConfig); err != nil {
+		return "", errors.Wrap(err, "merging cloud config from extra specs")
+	}
+
 	asStr, err := cloudCfg.Serialize()
 	if err != nil {
 		return "", errors.Wrap(err, "creating cloud config")
@@ -176,13 +214,51 @@ func GetCloudInitConfig(bootstrapParams params.BootstrapInstance, installScript
 	return asStr, nil
 }
 
+// GetMultipartUserData returns a multipart userdata holding the cloud-init config generated by
+// GetCloudInitConfig(), followed by the userdata parts set in the extra specs. The caller can then
+// serialize it, with or without gzip compression.
+func GetMultipartUserData(bootstrapParams params.BootstrapInstance, installScript []byte) (*MultipartUserData, error) {
+	extraSpecs, err := GetSpecs(bootstrapParams)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting specs")
+	}
+
+	cloudCfg, err := GetCloudInitConfig(bootstrapParams, installScript)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cloud init config")
+	}
+
+	userData := NewMultipartUserData()
+	if err := userData.AddPart(UserDataPart{
+		ContentType: PartCloudConfig,
+		Filename:    "garm-cloud-config.yaml",
+		Content:     []byte(cloudCfg),
+	}); err != nil {
+		return nil, errors.Wrap(err, "adding cloud config")
+	}
+
+	for _, part := range extraSpecs.UserDataParts {
+		if part.ContentType == PartCloudConfig && part.MergeType == "" {
+			part.MergeType = MergeTypeAppend
+		}
+		if err := userData.AddPart(part); err != nil {
+			return nil, errors.Wrap(err, "adding userdata part")
+		}
+	}
+
+	return userData, nil
+}
+
 // GetCloudConfig is a helper function that generates a cloud-init config for Linux and a powershell script for Windows.
 // In most cases this function should do, but in situations where a more custom approach is needed, you may need to call
 // GetCloudInitConfig() or GetRunnerInstallScript() directly and compose the final userdata in a different way.
 // The extra specs PreInstallScripts is only supported on Linux via cloud-init by this function. On some providers, like Azure
 // Windows initialization scripts are run by creating a separate CustomScriptExtension resource for each individual script.
 // On other clouds it may be different. This function aims to be generic, which is why it only supports the PreInstallScripts
-// via cloud-init.
+// via cloud-init. Linux distros that don't use cloud-init, like Flatcar and Fedora CoreOS, get an Ignition
+// config instead, generated by GetIgnitionConfig(). The distro is set using the "distro" extra spec. If the
+// extra specs hold userdata parts, the cloud-init config is returned as a MIME multipart document, generated
+// by GetMultipartUserData().
 func GetCloudConfig(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) (string, error) {
 	installScript, err := GetRunnerInstallScript(bootstrapParams, tools, runnerName)
 	if err != nil {
@@ -192,6 +268,26 @@ func GetCloudConfig(bootstrapParams params.BootstrapInstance, tools params.Runne
 	var asStr string
 	switch bootstrapParams.OSType {
 	case params.Linux:
+		extraSpecs, err := GetSpecs(bootstrapParams)
+		if err != nil {
+			return "", errors.Wrap(err, "getting specs")
+		}
+		if IsIgnitionDistro(extraSpecs.Distro) {
+			ignitionCfg, err := GetIgnitionConfig(bootstrapParams, installScript)
+			if err != nil {
+				return "", errors.Wrap(err, "getting ignition config")
+			}
+			return ignitionCfg, nil
+		}
+
+		if len(extraSpecs.UserDataParts) > 0 {
+			userData, err := GetMultipartUserData(bootstrapParams, installScript)
+			if err != nil {
+				return "", errors.Wrap(err, "getting multipart userdata")
+			}
+			return userData.Serialize()
+		}
+
 		cloudCfg, err := GetCloudInitConfig(bootstrapParams, installScript)
 		if err != nil {
 			return "", errors.Wrap(err, "getting cloud init config")
@@ -1,9 +1,11 @@
 package cloudconfig
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 
+	"github.com/cloudbase/garm-provider-common/cloudconfig/ignition"
 	"github.com/cloudbase/garm-provider-common/params"
 	"github.com/stretchr/testify/require"
 )
@@ -81,6 +83,41 @@ func TestGetRunnerInstallScript(t *testing.T) {
 	require.Equal(t, "test_template: test-runner-name - bogus-value1 - bogus-value2", string(script))
 }
 
+func TestGetRunnerInstallScriptChecksum(t *testing.T) {
+	checksum := "4b1c5f7a2ec64fb4b2fdc2c4f7b9a3c2a0e5d1b6f9c8e7d6a5b4c3d2e1f0a9b8"
+	withChecksum := tools
+	withChecksum.SHA256Checksum = &checksum
+
+	linuxParams := params.BootstrapInstance{OSType: params.Linux}
+	script, err := GetRunnerInstallScript(linuxParams, withChecksum, "test-runner-name")
+	require.NoError(t, err)
+	require.Contains(t, string(script), fmt.Sprintf(`echo "%s  /home/runner/test-filename" | sha256sum -c - || fail "runner checksum mismatch"`, checksum))
+
+	windowsParams := params.BootstrapInstance{OSType: params.Windows}
+	script, err = GetRunnerInstallScript(windowsParams, withChecksum, "test-runner-name")
+	require.NoError(t, err)
+	require.Contains(t, string(script), "(Get-FileHash -Path $downloadPath -Algorithm SHA256).Hash")
+	require.Contains(t, string(script), fmt.Sprintf(`if ($downloadHash -ne "%s") {`, checksum))
+
+	script, err = GetRunnerInstallScript(linuxParams, tools, "test-runner-name")
+	require.NoError(t, err)
+	require.NotContains(t, string(script), "sha256sum")
+}
+
+func TestGetRunnerInstallScriptInvalidChecksum(t *testing.T) {
+	for _, checksum := range []string{
+		"4b1c5f7a",
+		"4b1c5f7a2ec64fb4b2fdc2c4f7b9a3c2a0e5d1b6f9c8e7d6a5b4c3d2e1f0a9b8ff",
+		`4b1c5f7a2ec64fb4b2fdc2c4f7b9a3c2a0e5d1b6f9c8e7d6a5b4c3d2e1f0"; x`,
+	} {
+		withChecksum := tools
+		withChecksum.SHA256Checksum = &checksum
+
+		_, err := GetRunnerInstallScript(params.BootstrapInstance{OSType: params.Linux}, withChecksum, "test-runner-name")
+		require.EqualError(t, err, fmt.Sprintf("invalid tools SHA256 checksum: %q", checksum))
+	}
+}
+
 func TestGetRunnerInstallScriptMissingFilename(t *testing.T) {
 	_, err := GetRunnerInstallScript(bootstrapParams, params.RunnerApplicationDownload{}, "test-runner-name")
 	require.Error(t, err)
@@ -167,6 +204,63 @@ func TestGetCloudConfigForWindows(t *testing.T) {
 	require.Contains(t, cloudCfg, `#ps1_sysnative`)
 }
 
+func TestGetCloudConfigForFlatcar(t *testing.T) {
+	flatcarParams := params.BootstrapInstance{
+		OSType:     params.Linux,
+		SSHKeys:    []string{"ssh-rsa test-key"},
+		ExtraSpecs: []byte(`{"distro": "flatcar", "pre_install_scripts": {"01-test": "dGVzdA=="}}`),
+	}
+
+	cfg, err := GetCloudConfig(flatcarParams, tools, "test-runner-name")
+	require.NoError(t, err)
+	require.NotContains(t, cfg, "#cloud-config")
+
+	var ignitionCfg ignition.Config
+	require.NoError(t, json.Unmarshal([]byte(cfg), &ignitionCfg))
+	require.Equal(t, ignition.Version, ignitionCfg.Ignition.Version)
+	require.Len(t, ignitionCfg.Passwd.Users, 1)
+	require.Equal(t, "runner", ignitionCfg.Passwd.Users[0].Name)
+	require.Equal(t, []string{"ssh-rsa test-key"}, ignitionCfg.Passwd.Users[0].SSHAuthorizedKeys)
+
+	var paths []string
+	for _, file := range ignitionCfg.Storage.Files {
+		paths = append(paths, file.Path)
+	}
+	require.Equal(t, []string{"/etc/sudoers.d/runner", "/var/lib/garm/pre-install/01-test", "/var/lib/garm/install_runner.sh"}, paths)
+	require.Len(t, ignitionCfg.Systemd.Units, 1)
+	require.Contains(t, ignitionCfg.Systemd.Units[0].Contents, "ConditionPathExists=/var/lib/garm/install_runner.sh\n")
+	require.Contains(t, ignitionCfg.Systemd.Units[0].Contents, "ExecStart=/var/lib/garm/pre-install/01-test\nExecStart=/usr/bin/rm -rf /var/lib/garm/pre-install")
+	require.Contains(t, ignitionCfg.Systemd.Units[0].Contents, "ExecStart=/usr/bin/su -l -c /var/lib/garm/install_runner.sh runner")
+}
+
+func TestGetIgnitionConfigRejectsCloudInitFeatures(t *testing.T) {
+	flatcarParams := params.BootstrapInstance{
+		OSType:     params.Linux,
+		ExtraSpecs: []byte(`{"distro": "flatcar", "cloud_config": {"ntp": {"enabled": true}}}`),
+	}
+	_, err := GetIgnitionConfig(flatcarParams, []byte("script"))
+	require.EqualError(t, err, "the cloud_config extra spec is not supported on flatcar")
+
+	flatcarParams.ExtraSpecs = []byte(`{"distro": "fedora-coreos", "userdata_parts": [{"content_type": "text/x-shellscript", "content": "IyEvYmluL2Jhc2g="}]}`)
+	_, err = GetIgnitionConfig(flatcarParams, []byte("script"))
+	require.EqualError(t, err, "the userdata_parts extra spec is not supported on fedora-coreos")
+
+	flatcarParams.ExtraSpecs = []byte(`{"distro": "flatcar"}`)
+	flatcarParams.UserDataOptions.ExtraPackages = []string{"curl"}
+	_, err = GetIgnitionConfig(flatcarParams, []byte("script"))
+	require.EqualError(t, err, "extra packages are not supported on flatcar")
+}
+
+func TestGetIgnitionConfigUnknownDistro(t *testing.T) {
+	ubuntuParams := params.BootstrapInstance{
+		OSType:     params.Linux,
+		ExtraSpecs: []byte(`{"distro": "ubuntu"}`),
+	}
+
+	_, err := GetIgnitionConfig(ubuntuParams, []byte("script"))
+	require.EqualError(t, err, `distro "ubuntu" does not use ignition`)
+}
+
 func TestGetCloudConfigGeneratingScriptFailed(t *testing.T) {
 	_, err := GetCloudConfig(bootstrapParams, params.RunnerApplicationDownload{}, "test-runner-name")
 	require.Error(t, err)
@@ -45,15 +45,33 @@ func (b *baseError) Error() string {
 // NewProviderError returns a new ProviderError
 func NewProviderError(msg string, a ...interface{}) error {
 	return &ProviderError{
-		baseError{
+		baseError: baseError{
 			msg: fmt.Sprintf(msg, a...),
 		},
 	}
 }
 
-// UnauthorizedError is returned when a request is unauthorized
+// NewProviderErrorWithFault returns a new ProviderError that also holds the raw
+// fault details returned by the IaaS.
+func NewProviderErrorWithFault(fault []byte, msg string, a ...interface{}) error {
+	return &ProviderError{
+		baseError: baseError{
+			msg: fmt.Sprintf(msg, a...),
+		},
+		fault: fault,
+	}
+}
+
+// ProviderError is returned when the IaaS returns an error
 type ProviderError struct {
 	baseError
+
+	fault []byte
+}
+
+// Fault returns the raw fault details returned by the IaaS, if any.
+func (p *ProviderError) Fault() []byte {
+	return p.fault
 }
 
 // NewMissingSecretError returns a new MissingSecretError
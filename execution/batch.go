@@ -0,0 +1,180 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// DefaultBatchConcurrency is the number of items processed in parallel by the batch
+// commands, for providers that don't implement BatchProvider.
+const DefaultBatchConcurrency = 10
+
+// BatchCreateResult is the result of creating one instance as part of a CreateInstances
+// command.
+type BatchCreateResult struct {
+	// Name is the name of the instance, as set in the bootstrap params.
+	Name string `json:"name"`
+	// Instance is the created instance. It is only set on success.
+	Instance *params.ProviderInstance `json:"instance,omitempty"`
+	// Error is set if the instance could not be created.
+	Error *ErrorDocument `json:"error,omitempty"`
+}
+
+// NewBatchCreateResult returns the result of creating one instance.
+func NewBatchCreateResult(name string, instance params.ProviderInstance, err error) BatchCreateResult {
+	if err != nil {
+		return BatchCreateResult{
+			Name:  name,
+			Error: newBatchError(err),
+		}
+	}
+	return BatchCreateResult{
+		Name:     name,
+		Instance: &instance,
+	}
+}
+
+// Err returns the error of the item, or nil if it succeeded.
+func (r BatchCreateResult) Err() error {
+	if r.Error == nil {
+		return nil
+	}
+	return r.Error.Err()
+}
+
+// BatchDeleteResult is the result of deleting one instance as part of a DeleteInstances
+// command.
+type BatchDeleteResult struct {
+	// InstanceID is the ID of the instance.
+	InstanceID string `json:"instance_id"`
+	// Error is set if the instance could not be deleted.
+	Error *ErrorDocument `json:"error,omitempty"`
+}
+
+// NewBatchDeleteResult returns the result of deleting one instance.
+func NewBatchDeleteResult(instanceID string, err error) BatchDeleteResult {
+	return BatchDeleteResult{
+		InstanceID: instanceID,
+		Error:      newBatchError(err),
+	}
+}
+
+// Err returns the error of the item, or nil if it succeeded.
+func (r BatchDeleteResult) Err() error {
+	if r.Error == nil {
+		return nil
+	}
+	return r.Error.Err()
+}
+
+func newBatchError(err error) *ErrorDocument {
+	if err == nil {
+		return nil
+	}
+	doc := NewErrorDocument(err)
+	return &doc
+}
+
+// BatchProvider is an optional interface that providers can implement to create and
+// delete several instances at once, for example to share a single authentication with
+// the IaaS. Results must be returned in the same order as the input. Failures are
+// reported for each item. Providers that don't implement it get the single item methods
+// called on a bounded worker pool.
+type BatchProvider interface {
+	// CreateInstances creates several instances.
+	CreateInstances(ctx context.Context, bootstrapParams []params.BootstrapInstance) []BatchCreateResult
+	// DeleteInstances deletes several instances.
+	DeleteInstances(ctx context.Context, instances []string) []BatchDeleteResult
+}
+
+// CreateInstances creates the instances using the provider. If the provider implements
+// BatchProvider, it is used. Otherwise, at most concurrency instances are created at
+// the same time.
+func CreateInstances(ctx context.Context, provider ExternalProvider, bootstrapParams []params.BootstrapInstance, concurrency int) ([]BatchCreateResult, error) {
+	if batchProvider, ok := provider.(BatchProvider); ok {
+		results := batchProvider.CreateInstances(ctx, bootstrapParams)
+		if len(results) != len(bootstrapParams) {
+			return nil, fmt.Errorf("provider returned %d results for %d instances", len(results), len(bootstrapParams))
+		}
+		return results, nil
+	}
+
+	results := make([]BatchCreateResult, len(bootstrapParams))
+	forEach(len(bootstrapParams), concurrency, func(i int) {
+		instance, err := provider.CreateInstance(ctx, bootstrapParams[i])
+		results[i] = NewBatchCreateResult(bootstrapParams[i].Name, instance, err)
+	})
+	return results, nil
+}
+
+// DeleteInstances deletes the instances using the provider. If the provider implements
+// BatchProvider, it is used. Otherwise, at most concurrency instances are deleted at
+// the same time.
+func DeleteInstances(ctx context.Context, provider ExternalProvider, instances []string, concurrency int) ([]BatchDeleteResult, error) {
+	if batchProvider, ok := provider.(BatchProvider); ok {
+		results := batchProvider.DeleteInstances(ctx, instances)
+		if len(results) != len(instances) {
+			return nil, fmt.Errorf("provider returned %d results for %d instances", len(results), len(instances))
+		}
+		return results, nil
+	}
+
+	results := make([]BatchDeleteResult, len(instances))
+	forEach(len(instances), concurrency, func(i int) {
+		results[i] = NewBatchDeleteResult(instances[i], provider.DeleteInstance(ctx, instances[i]))
+	})
+	return results, nil
+}
+
+// batchTarget returns the provider the batch commands should use. Like the other optional
+// interfaces, BatchProvider is looked up on the undecorated provider. Otherwise, the single
+// item methods are called on the decorated one.
+func batchTarget(baseProvider, provider ExternalProvider) ExternalProvider {
+	if _, ok := baseProvider.(BatchProvider); ok {
+		return baseProvider
+	}
+	return provider
+}
+
+// forEach calls fn for every index in [0, count), running at most concurrency calls
+// at the same time.
+func forEach(count, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency && worker < count; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,124 @@
+package execution_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/fake"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+type batchProvider struct {
+	*fake.Provider
+	batches int
+}
+
+func (p *batchProvider) CreateInstances(ctx context.Context, bootstrapParams []params.BootstrapInstance) []execution.BatchCreateResult {
+	p.batches++
+	results := make([]execution.BatchCreateResult, len(bootstrapParams))
+	for i, param := range bootstrapParams {
+		instance, err := p.CreateInstance(ctx, param)
+		results[i] = execution.NewBatchCreateResult(param.Name, instance, err)
+	}
+	return results
+}
+
+func (p *batchProvider) DeleteInstances(ctx context.Context, instances []string) []execution.BatchDeleteResult {
+	p.batches++
+	results := make([]execution.BatchDeleteResult, len(instances))
+	for i, instance := range instances {
+		results[i] = execution.NewBatchDeleteResult(instance, p.DeleteInstance(ctx, instance))
+	}
+	return results
+}
+
+func batchBootstrapParams(count int) []params.BootstrapInstance {
+	ret := make([]params.BootstrapInstance, count)
+	for i := range ret {
+		ret[i] = params.BootstrapInstance{
+			Name:   string(rune('a' + i)),
+			PoolID: "pool",
+		}
+	}
+	return ret
+}
+
+func TestCreateInstancesPartialFailure(t *testing.T) {
+	provider := fake.NewProvider(fake.Config{})
+	provider.InjectError(execution.CreateInstanceCommand, gErrors.NewProviderError("quota exceeded"), 1)
+
+	results, err := execution.CreateInstances(context.Background(), provider, batchBootstrapParams(5), 1)
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+
+	var failed int
+	for i, result := range results {
+		require.Equal(t, string(rune('a'+i)), result.Name)
+		if result.Err() != nil {
+			failed++
+			require.Equal(t, execution.ErrorClassProviderError, result.Error.Class)
+			continue
+		}
+		require.Equal(t, result.Name, result.Instance.Name)
+	}
+	require.Equal(t, 1, failed)
+	require.Len(t, provider.Instances(), 4)
+}
+
+func TestCreateInstancesConcurrency(t *testing.T) {
+	var current, highest int32
+	track := execution.NewInterceptor(func(ctx context.Context, command execution.ExecutionCommand, call func(ctx context.Context) error) error {
+		val := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+		for {
+			old := atomic.LoadInt32(&highest)
+			if val <= old || atomic.CompareAndSwapInt32(&highest, old, val) {
+				break
+			}
+		}
+		return call(ctx)
+	})
+
+	provider := execution.Chain(fake.NewProvider(fake.Config{Latency: 5 * time.Millisecond}), track)
+	results, err := execution.CreateInstances(context.Background(), provider, batchBootstrapParams(10), 3)
+	require.NoError(t, err)
+	require.Len(t, results, 10)
+	require.LessOrEqual(t, atomic.LoadInt32(&highest), int32(3))
+}
+
+func TestRunBatchCommandsUseBatchProvider(t *testing.T) {
+	provider := &batchProvider{Provider: fake.NewProvider(fake.Config{})}
+	env := execution.Environment{
+		Command:              execution.CreateInstancesCommand,
+		InterfaceVersion:     execution.DefaultInterfaceVersion,
+		BatchBootstrapParams: batchBootstrapParams(3),
+	}
+
+	out, err := execution.Run(context.Background(), provider, env)
+	require.NoError(t, err)
+
+	var created []execution.BatchCreateResult
+	require.NoError(t, json.Unmarshal([]byte(out), &created))
+	require.Len(t, created, 3)
+	require.Equal(t, 1, provider.batches)
+
+	env = execution.Environment{
+		Command:          execution.DeleteInstancesCommand,
+		InterfaceVersion: execution.DefaultInterfaceVersion,
+		BatchInstanceIDs: []string{created[0].Instance.ProviderID, created[1].Instance.ProviderID},
+	}
+	out, err = execution.Run(context.Background(), provider, env)
+	require.NoError(t, err)
+
+	var deleted []execution.BatchDeleteResult
+	require.NoError(t, json.Unmarshal([]byte(out), &deleted))
+	require.Len(t, deleted, 2)
+	require.Equal(t, 2, provider.batches)
+	require.Len(t, provider.Instances(), 1)
+}
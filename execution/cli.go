@@ -0,0 +1,269 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// cliCommands maps the CLI subcommands to the commands they run.
+var cliCommands = map[string]ExecutionCommand{
+	"create":               CreateInstanceCommand,
+	"delete":               DeleteInstanceCommand,
+	"get":                  GetInstanceCommand,
+	"list":                 ListInstancesCommand,
+	"start":                StartInstanceCommand,
+	"stop":                 StopInstanceCommand,
+	"remove-all":           RemoveAllInstancesCommand,
+	"version":              GetVersionCommand,
+	"interface-versions":   GetSupportedInterfaceVersionsCommand,
+	"capabilities":         GetCapabilitiesCommand,
+	"create-batch":         CreateInstancesCommand,
+	"delete-batch":         DeleteInstancesCommand,
+	"reconcile":            ReconcileCommand,
+	"validate-extra-specs": ValidateExtraSpecsCommand,
+}
+
+func cliUsage(w io.Writer, name string) {
+	var subcommands []string
+	for subcommand := range cliCommands {
+		subcommands = append(subcommands, subcommand)
+	}
+	sort.Strings(subcommands)
+
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\n", name)
+	fmt.Fprintf(w, "Runs a single provider command, without GARM. Available commands:\n\n")
+	for _, subcommand := range subcommands {
+		fmt.Fprintf(w, "  %-20s runs %s\n", subcommand, cliCommands[subcommand])
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the list of flags.\n", name)
+}
+
+// ParseArgs builds an Environment from command line arguments, as an alternative to
+// GetEnvironment. The first argument is the command (eg: create, list), followed by
+// flags. Flags that are not set default to the value of the equivalent GARM_*
+// environment variable. Bootstrap params for the create command are read from the
+// file passed in via --bootstrap, or from stdin if the file name is "-".
+func ParseArgs(name string, args []string, stdin io.Reader, output io.Writer) (Environment, error) {
+	if len(args) == 0 {
+		cliUsage(output, name)
+		return Environment{}, fmt.Errorf("missing command")
+	}
+
+	command, ok := cliCommands[args[0]]
+	if !ok {
+		if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+			cliUsage(output, name)
+			return Environment{}, flag.ErrHelp
+		}
+		cliUsage(output, name)
+		return Environment{}, fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	env := Environment{
+		Command: command,
+	}
+	interfaceVersion := os.Getenv("GARM_INTERFACE_VERSION")
+	if interfaceVersion == "" {
+		interfaceVersion = DefaultInterfaceVersion
+	}
+	var inputFile, instanceIDs string
+	force := true
+
+	flags := flag.NewFlagSet(fmt.Sprintf("%s %s", name, args[0]), flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&env.ProviderConfigFile, "config", os.Getenv("GARM_PROVIDER_CONFIG_FILE"), "path to the provider config file")
+	flags.StringVar(&env.ControllerID, "controller-id", os.Getenv("GARM_CONTROLLER_ID"), "the GARM controller ID")
+	flags.StringVar(&env.InterfaceVersion, "interface-version", interfaceVersion, "the interface version to use")
+	switch command {
+	case CreateInstanceCommand:
+		flags.StringVar(&env.PoolID, "pool", os.Getenv("GARM_POOL_ID"), "the pool ID")
+		flags.StringVar(&inputFile, "bootstrap", "", "path to a JSON file holding the bootstrap params, or - for stdin")
+	case ListInstancesCommand:
+		flags.StringVar(&env.PoolID, "pool", os.Getenv("GARM_POOL_ID"), "the pool ID")
+		flags.StringVar((*string)(&env.ListOptions.Status), "status", "", "only list instances with this status")
+		flags.IntVar(&env.ListOptions.PageSize, "page-size", 0, "the maximum number of instances to list; lists all instances if 0")
+		flags.StringVar(&env.ListOptions.ContinuationToken, "continuation-token", "", "the continuation token returned with the previous page")
+		flags.StringVar((*string)(&env.OutputFormat), "output", "", "the output format: json (default) or ndjson")
+	case DeleteInstanceCommand, GetInstanceCommand, StartInstanceCommand:
+		flags.StringVar(&env.InstanceID, "instance", os.Getenv("GARM_INSTANCE_ID"), "the instance ID")
+	case CreateInstancesCommand:
+		flags.StringVar(&inputFile, "bootstrap", "", "path to a JSON file holding an array of bootstrap params, or - for stdin")
+		flags.IntVar(&env.BatchConcurrency, "concurrency", 0, "the number of instances to create in parallel")
+	case DeleteInstancesCommand:
+		flags.StringVar(&instanceIDs, "instances", "", "comma separated list of instance IDs")
+		flags.IntVar(&env.BatchConcurrency, "concurrency", 0, "the number of instances to delete in parallel")
+	case ReconcileCommand:
+		flags.StringVar(&env.PoolID, "pool", os.Getenv("GARM_POOL_ID"), "the pool ID")
+		flags.StringVar(&inputFile, "expected", "", "path to a JSON file holding the array of expected instances, or - for stdin")
+		flags.BoolVar(&env.DeleteOrphans, "delete-orphans", false, "delete the instances that are not expected")
+		flags.BoolVar(&env.AllowDeleteAll, "allow-delete-all", false, "allow deleting orphans when no instances are expected")
+		flags.IntVar(&env.BatchConcurrency, "concurrency", 0, "the number of orphans to delete in parallel")
+	case ValidateExtraSpecsCommand:
+		flags.StringVar(&inputFile, "extra-specs", "", "path to a JSON file holding the extra specs, or - for stdin")
+	case StopInstanceCommand:
+		flags.StringVar(&env.InstanceID, "instance", os.Getenv("GARM_INSTANCE_ID"), "the instance ID")
+		flags.BoolVar(&force, "force", true, "forcefully stop the instance")
+		flags.DurationVar(&env.GracefulStopTimeout, "graceful-timeout", 0, "when not forcing, the time to wait for a graceful shutdown before forcefully stopping the instance")
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return Environment{}, err
+	}
+
+	if flags.NArg() > 0 {
+		return Environment{}, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	env.GracefulStop = !force
+
+	switch command {
+	case CreateInstanceCommand:
+		data, err := readInputFile(inputFile, "--bootstrap", stdin)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		codec, err := getInterfaceCodec(env.InterfaceVersion)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		bootstrapParams, err := codec.decodeBootstrapParams(data)
+		if err != nil {
+			return Environment{}, err
+		}
+		if bootstrapParams.PoolID == "" {
+			bootstrapParams.PoolID = env.PoolID
+		}
+		env.BootstrapParams = bootstrapParams
+	case CreateInstancesCommand:
+		data, err := readInputFile(inputFile, "--bootstrap", stdin)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		if err := json.Unmarshal(data, &env.BatchBootstrapParams); err != nil {
+			return Environment{}, fmt.Errorf("failed to decode instance params: %w", err)
+		}
+	case ReconcileCommand:
+		data, err := readInputFile(inputFile, "--expected", stdin)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		if err := json.Unmarshal(data, &env.ExpectedInstances); err != nil {
+			return Environment{}, fmt.Errorf("failed to decode expected instances: %w", err)
+		}
+	case ValidateExtraSpecsCommand:
+		data, err := readInputFile(inputFile, "--extra-specs", stdin)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		if !json.Valid(data) {
+			return Environment{}, fmt.Errorf("extra specs are not valid json")
+		}
+		env.ExtraSpecs = data
+	case DeleteInstancesCommand:
+		for _, instanceID := range strings.Split(instanceIDs, ",") {
+			if instanceID = strings.TrimSpace(instanceID); instanceID != "" {
+				env.BatchInstanceIDs = append(env.BatchInstanceIDs, instanceID)
+			}
+		}
+	}
+
+	if err := env.Validate(); err != nil {
+		return Environment{}, fmt.Errorf("failed to validate execution environment: %w", err)
+	}
+
+	return env, nil
+}
+
+// readInputFile reads the file passed in via the given flag, or stdin if the file name is "-".
+func readInputFile(path, flagName string, stdin io.Reader) ([]byte, error) {
+	switch path {
+	case "":
+		return nil, fmt.Errorf("missing %s", flagName)
+	case "-":
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from stdin: %w", flagName, err)
+		}
+		return data, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", flagName, err)
+	}
+	return data, nil
+}
+
+// runCLI is the counterpart of run() for the CLI mode. Results are pretty printed
+// and errors are written in a human readable form.
+func runCLI(ctx context.Context, factory ProviderFactory, args []string, stdin io.Reader, stdout, stderr io.Writer, middlewares ...Middleware) int {
+	env, err := ParseArgs(args[0], args[1:], stdin, stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 2
+	}
+
+	timeout, err := GetCommandTimeout(env.Command)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	provider, err := factory(ctx, env)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: failed to create provider: %s\n", err)
+		return ResolveErrorToExitCode(err)
+	}
+
+	result, err := Run(ctx, provider, env, middlewares...)
+	if err != nil {
+		doc := NewErrorDocument(err)
+		fmt.Fprintf(stderr, "error (%s, retryable: %t): %s\n", doc.Class, doc.Retryable, doc.Message)
+		return doc.ExitCode
+	}
+
+	if len(result) == 0 {
+		fmt.Fprintf(stdout, "%s succeeded\n", env.Command)
+		return 0
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, []byte(result), "", "  "); err != nil {
+		fmt.Fprintln(stdout, result)
+		return 0
+	}
+	fmt.Fprintln(stdout, pretty.String())
+	return 0
+}
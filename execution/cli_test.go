@@ -0,0 +1,105 @@
+package execution
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func newCLIConfigFile(t *testing.T) string {
+	cfgFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(cfgFile, []byte{}, 0o600))
+	return cfgFile
+}
+
+func TestParseArgsList(t *testing.T) {
+	cfgFile := newCLIConfigFile(t)
+	t.Setenv("GARM_INTERFACE_VERSION", "")
+
+	env, err := ParseArgs("provider", []string{"list", "--config", cfgFile, "--controller-id", "ctrl", "--pool", "pool"}, nil, &bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, Environment{
+		Command:            ListInstancesCommand,
+		ControllerID:       "ctrl",
+		PoolID:             "pool",
+		ProviderConfigFile: cfgFile,
+		InterfaceVersion:   DefaultInterfaceVersion,
+	}, env)
+}
+
+func TestParseArgsInterfaceVersionFromEnvironment(t *testing.T) {
+	cfgFile := newCLIConfigFile(t)
+	t.Setenv("GARM_INTERFACE_VERSION", InterfaceVersion010)
+	args := []string{"list", "--config", cfgFile, "--controller-id", "ctrl", "--pool", "pool"}
+
+	env, err := ParseArgs("provider", args, nil, &bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, InterfaceVersion010, env.InterfaceVersion)
+
+	env, err = ParseArgs("provider", append(args, "--interface-version", InterfaceVersion011), nil, &bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, InterfaceVersion011, env.InterfaceVersion)
+}
+
+func TestParseArgsCreateFromStdin(t *testing.T) {
+	cfgFile := newCLIConfigFile(t)
+	stdin := strings.NewReader(`{"name": "test-instance", "os_type": "linux", "arch": "amd64"}`)
+
+	env, err := ParseArgs("provider", []string{"create", "--config", cfgFile, "--controller-id", "ctrl", "--pool", "pool", "--bootstrap", "-"}, stdin, &bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, CreateInstanceCommand, env.Command)
+	require.Equal(t, "test-instance", env.BootstrapParams.Name)
+	require.Equal(t, "pool", env.BootstrapParams.PoolID)
+	require.Equal(t, params.Linux, env.BootstrapParams.OSType)
+}
+
+func TestParseArgsCreateMissingBootstrap(t *testing.T) {
+	cfgFile := newCLIConfigFile(t)
+
+	_, err := ParseArgs("provider", []string{"create", "--config", cfgFile, "--controller-id", "ctrl", "--pool", "pool"}, nil, &bytes.Buffer{})
+	require.EqualError(t, err, "missing --bootstrap")
+}
+
+func TestParseArgsUnknownCommand(t *testing.T) {
+	var output bytes.Buffer
+	_, err := ParseArgs("provider", []string{"bogus"}, nil, &output)
+	require.EqualError(t, err, "unknown command: bogus")
+	require.Contains(t, output.String(), "Usage: provider <command> [flags]")
+}
+
+func TestParseArgsUnknownFlag(t *testing.T) {
+	_, err := ParseArgs("provider", []string{"list", "--instance", "bogus"}, nil, &bytes.Buffer{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "flag provided but not defined: -instance")
+}
+
+func TestRunCLI(t *testing.T) {
+	cfgFile := newCLIConfigFile(t)
+	provider := newTestProvider()
+	provider.instances["test-instance"] = params.ProviderInstance{
+		Name:   "test-instance",
+		Status: params.InstanceRunning,
+	}
+	factory := func(ctx context.Context, env Environment) (ExternalProvider, error) {
+		return provider, nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"provider", "get", "--config", cfgFile, "--controller-id", "ctrl", "--instance", "test-instance"}
+	code := runCLI(context.Background(), factory, args, nil, &stdout, &stderr)
+	require.Equal(t, 0, code)
+	require.Equal(t, "{\n  \"name\": \"test-instance\",\n  \"status\": \"running\"\n}\n", stdout.String())
+
+	stdout.Reset()
+	args = []string{"provider", "get", "--config", cfgFile, "--controller-id", "ctrl", "--instance", "missing"}
+	code = runCLI(context.Background(), factory, args, nil, &stdout, &stderr)
+	require.Equal(t, ExitCodeNotFound, code)
+	require.Empty(t, stdout.String())
+	require.Contains(t, stderr.String(), "error (NotFound, retryable: false)")
+}
@@ -0,0 +1,462 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package client implements an execution.ExternalProvider that drives an external
+// provider binary, using the same protocol GARM uses.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/params"
+	commonExec "github.com/cloudbase/garm-provider-common/util/exec"
+)
+
+var (
+	_ execution.ExternalProvider         = &Provider{}
+	_ execution.PagedLister              = &Provider{}
+	_ execution.BatchProvider            = &Provider{}
+	_ execution.VersionProvider          = &Provider{}
+	_ execution.InterfaceVersionProvider = &Provider{}
+)
+
+// Config holds the information needed to execute an external provider binary.
+type Config struct {
+	// ProviderBinary is the path to the external provider executable.
+	ProviderBinary string
+	// ProviderConfigFile is the path to the config file of the provider. It is passed
+	// to the provider via GARM_PROVIDER_CONFIG_FILE.
+	ProviderConfigFile string
+	// ControllerID is the ID of the GARM controller. It is passed to the provider via
+	// GARM_CONTROLLER_ID.
+	ControllerID string
+	// InterfaceVersion is the interface version the provider is expected to speak. If
+	// empty, execution.DefaultInterfaceVersion is used.
+	InterfaceVersion string
+	// ExtraSpecs holds the pool extra specs that are passed to the provider starting
+	// with interface version v0.1.1.
+	ExtraSpecs json.RawMessage
+	// GracefulStopTimeout is the amount of time the provider waits for an instance to shut
+	// down gracefully, when Stop() is called without force, before stopping it forcefully.
+	// If zero, the instance is never forcefully stopped in that case.
+	GracefulStopTimeout time.Duration
+	// Environment is a list of extra environment variables, in the form of key=value,
+	// that will be set when executing the provider. If nil, the environment of the
+	// current process is used, without the GARM_* variables, as those are set for each
+	// call and must not leak from the parent process.
+	Environment []string
+}
+
+// Validate checks that the config is usable.
+func (c Config) Validate() error {
+	if c.ProviderBinary == "" {
+		return fmt.Errorf("missing provider binary")
+	}
+
+	if _, err := os.Stat(c.ProviderBinary); err != nil {
+		return fmt.Errorf("failed to access provider binary: %w", err)
+	}
+
+	if !commonExec.IsExecutable(c.ProviderBinary) {
+		return fmt.Errorf("provider binary %s is not executable", c.ProviderBinary)
+	}
+
+	if c.ProviderConfigFile == "" {
+		return fmt.Errorf("missing provider config file")
+	}
+
+	if c.ControllerID == "" {
+		return fmt.Errorf("missing controller ID")
+	}
+	return nil
+}
+
+// runner executes a single command against a provider and returns its stdout.
+type runner interface {
+	run(ctx context.Context, env execution.Environment) ([]byte, error)
+}
+
+// NewProvider returns a new Provider that executes the provider binary described by cfg.
+func NewProvider(cfg Config) (*Provider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+
+	environ := cfg.Environment
+	if environ == nil {
+		environ = withoutGarmEnv(os.Environ())
+	}
+
+	return &Provider{
+		baseEnv: execution.Environment{
+			ControllerID:        cfg.ControllerID,
+			ProviderConfigFile:  cfg.ProviderConfigFile,
+			InterfaceVersion:    cfg.InterfaceVersion,
+			ExtraSpecs:          cfg.ExtraSpecs,
+			GracefulStopTimeout: cfg.GracefulStopTimeout,
+		},
+		runner: &binaryRunner{
+			binary:  cfg.ProviderBinary,
+			environ: environ,
+		},
+	}, nil
+}
+
+// Provider is an execution.ExternalProvider that forwards all calls to an external provider.
+type Provider struct {
+	baseEnv execution.Environment
+	runner  runner
+}
+
+func (p *Provider) environment(command execution.ExecutionCommand) execution.Environment {
+	env := p.baseEnv
+	env.Command = command
+	return env
+}
+
+func (p *Provider) run(ctx context.Context, env execution.Environment, result interface{}) error {
+	out, err := p.runner.run(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := env.DecodeResult(out, result); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", env.Command, err)
+	}
+	return nil
+}
+
+// CreateInstance creates a new compute instance in the provider.
+func (p *Provider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	env := p.environment(execution.CreateInstanceCommand)
+	env.PoolID = bootstrapParams.PoolID
+	env.BootstrapParams = bootstrapParams
+
+	var instance params.ProviderInstance
+	if err := p.run(ctx, env, &instance); err != nil {
+		return params.ProviderInstance{}, err
+	}
+	return instance, nil
+}
+
+// CreateInstances creates several instances with a single execution of the provider. If
+// the execution fails, the error is reported for every instance.
+func (p *Provider) CreateInstances(ctx context.Context, bootstrapParams []params.BootstrapInstance) []execution.BatchCreateResult {
+	env := p.environment(execution.CreateInstancesCommand)
+	env.BatchBootstrapParams = bootstrapParams
+
+	var results []execution.BatchCreateResult
+	err := p.run(ctx, env, &results)
+	if err == nil && len(results) != len(bootstrapParams) {
+		err = fmt.Errorf("provider returned %d results for %d instances", len(results), len(bootstrapParams))
+	}
+	if err != nil {
+		results = make([]execution.BatchCreateResult, len(bootstrapParams))
+		for i, param := range bootstrapParams {
+			results[i] = execution.NewBatchCreateResult(param.Name, params.ProviderInstance{}, err)
+		}
+	}
+	return results
+}
+
+// DeleteInstances deletes several instances with a single execution of the provider. If
+// the execution fails, the error is reported for every instance.
+func (p *Provider) DeleteInstances(ctx context.Context, instances []string) []execution.BatchDeleteResult {
+	env := p.environment(execution.DeleteInstancesCommand)
+	env.BatchInstanceIDs = instances
+
+	var results []execution.BatchDeleteResult
+	err := p.run(ctx, env, &results)
+	if err == nil && len(results) != len(instances) {
+		err = fmt.Errorf("provider returned %d results for %d instances", len(results), len(instances))
+	}
+	if err != nil {
+		results = make([]execution.BatchDeleteResult, len(instances))
+		for i, instance := range instances {
+			results[i] = execution.NewBatchDeleteResult(instance, err)
+		}
+	}
+	return results
+}
+
+// Reconcile compares the instances GARM expects in a pool with the ones that exist in the
+// provider. The options are passed to the provider, which deletes the instances that are not
+// expected if opts.DeleteOrphans is true.
+func (p *Provider) Reconcile(ctx context.Context, poolID string, expected []execution.ExpectedInstance, opts execution.ReconcileOptions) (execution.ReconcileReport, error) {
+	env := p.environment(execution.ReconcileCommand)
+	env.PoolID = poolID
+	env.ExpectedInstances = expected
+	env.DeleteOrphans = opts.DeleteOrphans
+	env.BatchConcurrency = opts.Concurrency
+	env.AllowDeleteAll = opts.AllowDeleteAll
+
+	var report execution.ReconcileReport
+	if err := p.run(ctx, env, &report); err != nil {
+		return execution.ReconcileReport{}, err
+	}
+	return report, nil
+}
+
+// ValidateExtraSpecs asks the provider to validate the extra specs of a pool. Invalid
+// extra specs are reported as a BadRequest error.
+func (p *Provider) ValidateExtraSpecs(ctx context.Context, extraSpecs json.RawMessage) error {
+	env := p.environment(execution.ValidateExtraSpecsCommand)
+	env.ExtraSpecs = extraSpecs
+
+	return p.run(ctx, env, nil)
+}
+
+// DeleteInstance will delete the instance in a provider.
+func (p *Provider) DeleteInstance(ctx context.Context, instance string) error {
+	env := p.environment(execution.DeleteInstanceCommand)
+	env.InstanceID = instance
+
+	return p.run(ctx, env, nil)
+}
+
+// GetInstance will return details about one instance.
+func (p *Provider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
+	env := p.environment(execution.GetInstanceCommand)
+	env.InstanceID = instance
+
+	var ret params.ProviderInstance
+	if err := p.run(ctx, env, &ret); err != nil {
+		return params.ProviderInstance{}, err
+	}
+	return ret, nil
+}
+
+// ListInstances will list all instances for a provider.
+func (p *Provider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
+	env := p.environment(execution.ListInstancesCommand)
+	env.PoolID = poolID
+
+	var ret []params.ProviderInstance
+	if err := p.run(ctx, env, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ListInstancesPage returns one page of instances in a pool. The provider is asked to
+// stream the result as NDJSON. Providers that don't support pagination return the
+// whole pool, as a single page.
+func (p *Provider) ListInstancesPage(ctx context.Context, poolID string, opts execution.ListInstancesOptions) (execution.ListInstancesPage, error) {
+	env := p.environment(execution.ListInstancesCommand)
+	env.PoolID = poolID
+	env.ListOptions = opts
+	env.OutputFormat = execution.OutputFormatNDJSON
+
+	out, err := p.runner.run(ctx, env)
+	if err != nil {
+		return execution.ListInstancesPage{}, err
+	}
+
+	page, err := decodeNDJSONPage(env, out)
+	if err != nil {
+		return execution.ListInstancesPage{}, fmt.Errorf("failed to decode %s result: %w", env.Command, err)
+	}
+	return page, nil
+}
+
+// ndjsonLine is a line of the NDJSON output, which is either an instance or the trailer.
+type ndjsonLine struct {
+	params.ProviderInstance
+	ContinuationToken string `json:"continuation_token,omitempty"`
+}
+
+func decodeNDJSONPage(env execution.Environment, out []byte) (execution.ListInstancesPage, error) {
+	page := execution.ListInstancesPage{
+		Instances: []params.ProviderInstance{},
+	}
+
+	trimmed := bytes.TrimSpace(out)
+	if bytes.Equal(trimmed, []byte("null")) {
+		// Older providers encode an empty pool as null.
+		return page, nil
+	}
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		// The provider does not know about NDJSON and returned a JSON array.
+		if err := env.DecodeResult(trimmed, &page.Instances); err != nil {
+			return execution.ListInstancesPage{}, err
+		}
+		return page, nil
+	}
+
+	for _, line := range bytes.Split(trimmed, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var decoded ndjsonLine
+		if err := env.DecodeResult(line, &decoded); err != nil {
+			return execution.ListInstancesPage{}, err
+		}
+		if decoded.ProviderID == "" {
+			// A line without a provider ID is either the continuation token, or not
+			// an instance at all (eg: a null).
+			if decoded.ContinuationToken != "" {
+				page.ContinuationToken = decoded.ContinuationToken
+			}
+			continue
+		}
+		page.Instances = append(page.Instances, decoded.ProviderInstance)
+	}
+	return page, nil
+}
+
+// RemoveAllInstances will remove all instances created by this provider.
+func (p *Provider) RemoveAllInstances(ctx context.Context) error {
+	return p.run(ctx, p.environment(execution.RemoveAllInstancesCommand), nil)
+}
+
+// Stop shuts down the instance. If force is false, the provider attempts a graceful
+// shutdown first.
+func (p *Provider) Stop(ctx context.Context, instance string, force bool) error {
+	env := p.environment(execution.StopInstanceCommand)
+	env.InstanceID = instance
+	env.GracefulStop = !force
+
+	return p.run(ctx, env, nil)
+}
+
+// Start boots up an instance.
+func (p *Provider) Start(ctx context.Context, instance string) error {
+	env := p.environment(execution.StartInstanceCommand)
+	env.InstanceID = instance
+
+	return p.run(ctx, env, nil)
+}
+
+// GetVersion returns the version of the provider.
+func (p *Provider) GetVersion(ctx context.Context) (string, error) {
+	var ret string
+	if err := p.run(ctx, p.environment(execution.GetVersionCommand), &ret); err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// GetSupportedInterfaceVersions returns the interface versions the provider supports.
+func (p *Provider) GetSupportedInterfaceVersions(ctx context.Context) ([]string, error) {
+	var ret []string
+	if err := p.run(ctx, p.environment(execution.GetSupportedInterfaceVersionsCommand), &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetCapabilities returns the capabilities of the provider.
+func (p *Provider) GetCapabilities(ctx context.Context) (params.ProviderCapabilities, error) {
+	var ret params.ProviderCapabilities
+	if err := p.run(ctx, p.environment(execution.GetCapabilitiesCommand), &ret); err != nil {
+		return params.ProviderCapabilities{}, err
+	}
+	return ret, nil
+}
+
+type binaryRunner struct {
+	binary  string
+	environ []string
+}
+
+func (b *binaryRunner) run(ctx context.Context, env execution.Environment) ([]byte, error) {
+	environ, err := env.Environ()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider environment: %w", err)
+	}
+
+	stdin, err := env.Stdin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider stdin: %w", err)
+	}
+
+	// Environment variables set later in the list take precedence.
+	stdout, stderr, err := commonExec.Run(ctx, b.binary, stdin, append(append([]string{}, b.environ...), environ...))
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("provider binary failed to run %s: %w", env.Command, ctxErr)
+		}
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("failed to execute provider binary: %w", err)
+		}
+		return nil, errorFromOutput(exitErr.ExitCode(), stderr)
+	}
+
+	return stdout, nil
+}
+
+// withoutGarmEnv returns the environment without the GARM_* variables.
+func withoutGarmEnv(environ []string) []string {
+	ret := make([]string, 0, len(environ))
+	for _, val := range environ {
+		if strings.HasPrefix(val, "GARM_") {
+			continue
+		}
+		ret = append(ret, val)
+	}
+	return ret
+}
+
+// ExitError is returned when the provider binary exits with a non-zero exit code. It
+// wraps the error decoded from the output of the provider.
+type ExitError struct {
+	// ExitCode is the exit code of the provider process.
+	ExitCode int
+	// Err is the error decoded from the output of the provider.
+	Err error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// errorFromOutput converts the exit code and stderr of a failed provider into an error.
+// Providers that use execution.Main() write an execution.ErrorDocument as the last line
+// of stderr. For other providers, the error type is inferred from the exit code.
+func errorFromOutput(exitCode int, stderr []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(stderr)), "\n")
+	lastLine := lines[len(lines)-1]
+
+	var doc execution.ErrorDocument
+	if err := json.Unmarshal([]byte(lastLine), &doc); err == nil && doc.Class != "" {
+		return &ExitError{ExitCode: exitCode, Err: doc.Err()}
+	}
+
+	doc = execution.ErrorDocument{
+		Class:    execution.ResolveExitCodeToClass(exitCode),
+		Message:  fmt.Sprintf("provider binary failed with exit code %d; stderr: %s", exitCode, strings.TrimSpace(string(stderr))),
+		ExitCode: exitCode,
+	}
+	return &ExitError{ExitCode: exitCode, Err: doc.Err()}
+}
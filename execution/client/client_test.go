@@ -0,0 +1,284 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+// helperProvider is executed by the test binary itself, when it is started with
+// CLIENT_TEST_HELPER set. It behaves like a real external provider.
+type helperProvider struct{}
+
+func (h *helperProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	if bootstrapParams.Name == "quota" {
+		return params.ProviderInstance{}, gErrors.NewProviderErrorWithFault([]byte("quota exceeded"), "failed to create instance")
+	}
+	return params.ProviderInstance{
+		ProviderID: "id-" + bootstrapParams.Name,
+		Name:       bootstrapParams.Name,
+		OSType:     bootstrapParams.OSType,
+		OSArch:     bootstrapParams.OSArch,
+		Status:     params.InstanceRunning,
+	}, nil
+}
+
+func (h *helperProvider) DeleteInstance(ctx context.Context, instance string) error {
+	return nil
+}
+
+func (h *helperProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
+	if instance == "missing" {
+		return params.ProviderInstance{}, gErrors.NewNotFoundError("instance %s not found", instance)
+	}
+	return params.ProviderInstance{
+		ProviderID: instance,
+		Name:       instance,
+		Status:     params.InstanceRunning,
+	}, nil
+}
+
+func (h *helperProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
+	return []params.ProviderInstance{
+		{
+			ProviderID: poolID + "-1",
+			Name:       poolID + "-1",
+			Status:     params.InstanceRunning,
+		},
+	}, nil
+}
+
+func (h *helperProvider) RemoveAllInstances(ctx context.Context) error {
+	return gErrors.NewUnauthorizedError("bad credentials")
+}
+
+func (h *helperProvider) Stop(ctx context.Context, instance string, force bool) error {
+	return nil
+}
+
+func (h *helperProvider) Start(ctx context.Context, instance string) error {
+	return nil
+}
+
+func (h *helperProvider) GetVersion(ctx context.Context) (string, error) {
+	return "v0.0.1", nil
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv("CLIENT_TEST_HELPER") != "" {
+		execution.Main(func(ctx context.Context, env execution.Environment) (execution.ExternalProvider, error) {
+			return &helperProvider{}, nil
+		})
+	}
+	os.Exit(m.Run())
+}
+
+func newTestClient(t *testing.T) *Provider {
+	cfgFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(cfgFile, []byte{}, 0o600))
+
+	provider, err := NewProvider(Config{
+		ProviderBinary:     os.Args[0],
+		ProviderConfigFile: cfgFile,
+		ControllerID:       "test-controller",
+		Environment:        []string{"CLIENT_TEST_HELPER=1"},
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+func TestNewProviderInvalidConfig(t *testing.T) {
+	_, err := NewProvider(Config{})
+	require.EqualError(t, err, "failed to validate config: missing provider binary")
+
+	_, err = NewProvider(Config{
+		ProviderBinary: os.Args[0],
+	})
+	require.EqualError(t, err, "failed to validate config: missing provider config file")
+}
+
+func TestCreateInstance(t *testing.T) {
+	provider := newTestClient(t)
+
+	instance, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{
+		Name:   "test-instance",
+		PoolID: "test-pool",
+		OSType: params.Linux,
+		OSArch: params.Amd64,
+	})
+	require.NoError(t, err)
+	require.Equal(t, params.ProviderInstance{
+		ProviderID: "id-test-instance",
+		Name:       "test-instance",
+		OSType:     params.Linux,
+		OSArch:     params.Amd64,
+		Status:     params.InstanceRunning,
+	}, instance)
+}
+
+func TestCreateInstanceProviderError(t *testing.T) {
+	provider := newTestClient(t)
+
+	_, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{
+		Name:   "quota",
+		PoolID: "test-pool",
+		OSType: params.Linux,
+		OSArch: params.Amd64,
+	})
+	require.Error(t, err)
+
+	var providerErr *gErrors.ProviderError
+	require.True(t, errors.As(err, &providerErr))
+	require.Equal(t, []byte("quota exceeded"), providerErr.Fault())
+	require.True(t, execution.IsRetryable(err))
+}
+
+func TestGetInstanceNotFound(t *testing.T) {
+	provider := newTestClient(t)
+
+	_, err := provider.GetInstance(context.Background(), "missing")
+	require.Error(t, err)
+	require.True(t, errors.Is(err, gErrors.ErrNotFound))
+	require.Equal(t, execution.ExitCodeNotFound, execution.ResolveErrorToExitCode(err))
+}
+
+func TestListInstances(t *testing.T) {
+	provider := newTestClient(t)
+
+	instances, err := provider.ListInstances(context.Background(), "test-pool")
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, "test-pool-1", instances[0].Name)
+}
+
+func TestRemoveAllInstancesUnauthorized(t *testing.T) {
+	provider := newTestClient(t)
+
+	err := provider.RemoveAllInstances(context.Background())
+	require.Error(t, err)
+	require.True(t, errors.Is(err, gErrors.ErrUnauthorized))
+}
+
+func TestGetVersion(t *testing.T) {
+	provider := newTestClient(t)
+
+	version, err := provider.GetVersion(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "v0.0.1", version)
+}
+
+func TestParentGarmEnvironmentIsNotInherited(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(cfgFile, []byte{}, 0o600))
+
+	// If inherited, this would make the provider serve on a socket instead of
+	// running the command.
+	t.Setenv("GARM_PROVIDER_SOCKET", filepath.Join(t.TempDir(), "provider.sock"))
+	t.Setenv("GARM_POOL_ID", "parent-pool")
+	t.Setenv("CLIENT_TEST_HELPER", "1")
+
+	provider, err := NewProvider(Config{
+		ProviderBinary:     os.Args[0],
+		ProviderConfigFile: cfgFile,
+		ControllerID:       "test-controller",
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	instances, err := provider.ListInstances(ctx, "test-pool")
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, "test-pool-1", instances[0].ProviderID)
+
+	require.Equal(t, []string{"PATH=/bin"}, withoutGarmEnv([]string{"GARM_PROVIDER_SOCKET=/tmp/sock", "PATH=/bin", "GARM_POOL_ID=pool"}))
+}
+
+func TestErrorFromOutputExitCode(t *testing.T) {
+	err := errorFromOutput(execution.ExitCodeDuplicate, []byte("some free form error"))
+	require.True(t, errors.Is(err, gErrors.ErrDuplicateEntity))
+	require.Contains(t, err.Error(), "some free form error")
+
+	err = errorFromOutput(1, nil)
+	require.EqualError(t, err, "provider binary failed with exit code 1; stderr: ")
+
+	// The exit code of the process is kept, even if it does not match the error document.
+	err = errorFromOutput(1, []byte(`{"class": "NotFound", "message": "instance not found", "exit_code": 30}`))
+	require.True(t, errors.Is(err, gErrors.ErrNotFound))
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, 1, exitErr.ExitCode)
+}
+
+func TestListInstancesPage(t *testing.T) {
+	provider := newTestClient(t)
+
+	page, err := provider.ListInstancesPage(context.Background(), "test-pool", execution.ListInstancesOptions{
+		Status: params.InstanceRunning,
+	})
+	require.NoError(t, err)
+	require.Len(t, page.Instances, 1)
+	require.Equal(t, "test-pool-1", page.Instances[0].Name)
+	require.Empty(t, page.ContinuationToken)
+
+	page, err = provider.ListInstancesPage(context.Background(), "test-pool", execution.ListInstancesOptions{
+		Status: params.InstanceStopped,
+	})
+	require.NoError(t, err)
+	require.Empty(t, page.Instances)
+}
+
+func TestDecodeNDJSONPage(t *testing.T) {
+	env := execution.Environment{InterfaceVersion: execution.DefaultInterfaceVersion}
+
+	page, err := decodeNDJSONPage(env, []byte("{\"provider_id\":\"a\"}\n{\"provider_id\":\"b\"}\n{\"continuation_token\":\"b\"}\n"))
+	require.NoError(t, err)
+	require.Len(t, page.Instances, 2)
+	require.Equal(t, "b", page.ContinuationToken)
+
+	page, err = decodeNDJSONPage(env, []byte(`[{"provider_id":"a"}]`))
+	require.NoError(t, err)
+	require.Len(t, page.Instances, 1)
+	require.Empty(t, page.ContinuationToken)
+	// Older providers print null for an empty pool.
+	for _, out := range []string{"null", "null\n", "[]", "{}\n"} {
+		page, err = decodeNDJSONPage(env, []byte(out))
+		require.NoError(t, err)
+		require.Empty(t, page.Instances, "output %q", out)
+		require.Empty(t, page.ContinuationToken, "output %q", out)
+	}
+}
+
+func TestCreateInstances(t *testing.T) {
+	provider := newTestClient(t)
+
+	results := provider.CreateInstances(context.Background(), []params.BootstrapInstance{
+		{Name: "first", PoolID: "test-pool", OSType: params.Linux, OSArch: params.Amd64},
+		{Name: "quota", PoolID: "test-pool", OSType: params.Linux, OSArch: params.Amd64},
+	})
+	require.Len(t, results, 2)
+	require.NoError(t, results[0].Err())
+	require.Equal(t, "id-first", results[0].Instance.ProviderID)
+
+	var providerErr *gErrors.ProviderError
+	require.True(t, errors.As(results[1].Err(), &providerErr))
+	require.Nil(t, results[1].Instance)
+}
+
+func TestDeleteInstances(t *testing.T) {
+	provider := newTestClient(t)
+
+	results := provider.DeleteInstances(context.Background(), []string{"first", "second"})
+	require.Equal(t, []execution.BatchDeleteResult{
+		{InstanceID: "first"},
+		{InstanceID: "second"},
+	}, results)
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/execution"
+)
+
+// SocketConfig holds the information needed to talk to a provider running in server
+// mode. The controller ID and provider config file are owned by the server.
+type SocketConfig struct {
+	// SocketPath is the path to the unix socket the provider listens on.
+	SocketPath string
+	// InterfaceVersion is the interface version the provider is expected to speak. If
+	// empty, execution.DefaultInterfaceVersion is used.
+	InterfaceVersion string
+	// ExtraSpecs holds the pool extra specs that are passed to the provider starting
+	// with interface version v0.1.1.
+	ExtraSpecs json.RawMessage
+	// GracefulStopTimeout is the amount of time the provider waits for an instance to shut
+	// down gracefully, when Stop() is called without force, before stopping it forcefully.
+	GracefulStopTimeout time.Duration
+}
+
+// Validate checks that the config is usable.
+func (c SocketConfig) Validate() error {
+	if c.SocketPath == "" {
+		return fmt.Errorf("missing socket path")
+	}
+	return nil
+}
+
+// NewSocketProvider returns a new Provider that sends commands to a provider running
+// in server mode. See execution.Server.
+func NewSocketProvider(cfg SocketConfig) (*Provider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+
+	dialer := &net.Dialer{}
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", cfg.SocketPath)
+			},
+		},
+	}
+
+	return &Provider{
+		baseEnv: execution.Environment{
+			InterfaceVersion:    cfg.InterfaceVersion,
+			ExtraSpecs:          cfg.ExtraSpecs,
+			GracefulStopTimeout: cfg.GracefulStopTimeout,
+		},
+		runner: &socketRunner{
+			client: httpClient,
+		},
+	}, nil
+}
+
+type socketRunner struct {
+	client *http.Client
+}
+
+func (s *socketRunner) run(ctx context.Context, env execution.Environment) ([]byte, error) {
+	body, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// The host is ignored, as we always dial the unix socket.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://provider"+execution.ServerRunPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to provider: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provider response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var doc execution.ErrorDocument
+		if err := json.Unmarshal(data, &doc); err != nil || doc.Class == "" {
+			return nil, fmt.Errorf("provider returned status %d: %s", resp.StatusCode, string(data))
+		}
+		return nil, doc.Err()
+	}
+	return data, nil
+}
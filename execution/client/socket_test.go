@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSocketClient(t *testing.T) *Provider {
+	// Unix socket paths are limited in length, so we avoid t.TempDir().
+	dir, err := os.MkdirTemp("", "garm")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cfgFile := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(cfgFile, []byte{}, 0o600))
+	socketPath := filepath.Join(dir, "provider.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		srv := execution.NewServer(&helperProvider{}, "test-controller", cfgFile)
+		errCh <- srv.ServeUnix(ctx, socketPath)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-errCh)
+	})
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	provider, err := NewSocketProvider(SocketConfig{
+		SocketPath: socketPath,
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+func TestSocketProviderCreateInstance(t *testing.T) {
+	provider := newTestSocketClient(t)
+
+	instance, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{
+		Name:   "test-instance",
+		PoolID: "test-pool",
+		OSType: params.Linux,
+		OSArch: params.Amd64,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "id-test-instance", instance.ProviderID)
+	require.Equal(t, params.InstanceRunning, instance.Status)
+}
+
+func TestSocketProviderErrors(t *testing.T) {
+	provider := newTestSocketClient(t)
+
+	_, err := provider.GetInstance(context.Background(), "missing")
+	require.True(t, errors.Is(err, gErrors.ErrNotFound))
+
+	err = provider.RemoveAllInstances(context.Background())
+	require.True(t, errors.Is(err, gErrors.ErrUnauthorized))
+
+	_, err = provider.CreateInstance(context.Background(), params.BootstrapInstance{
+		Name:   "quota",
+		PoolID: "test-pool",
+		OSType: params.Linux,
+		OSArch: params.Amd64,
+	})
+	var providerErr *gErrors.ProviderError
+	require.True(t, errors.As(err, &providerErr))
+	require.Equal(t, []byte("quota exceeded"), providerErr.Fault())
+}
+
+func TestSocketProviderInvalidCommand(t *testing.T) {
+	provider := newTestSocketClient(t)
+
+	err := provider.run(context.Background(), provider.environment("bogus"), nil)
+	require.True(t, errors.Is(err, gErrors.ErrBadRequest))
+	require.Contains(t, err.Error(), "unknown GARM_COMMAND: bogus")
+}
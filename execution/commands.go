@@ -24,4 +24,23 @@ const (
 	StartInstanceCommand      ExecutionCommand = "StartInstance"
 	StopInstanceCommand       ExecutionCommand = "StopInstance"
 	RemoveAllInstancesCommand ExecutionCommand = "RemoveAllInstances"
+	// GetVersionCommand returns the version of the provider.
+	GetVersionCommand ExecutionCommand = "GetVersion"
+	// GetSupportedInterfaceVersionsCommand returns the list of interface versions
+	// the provider is able to speak.
+	GetSupportedInterfaceVersionsCommand ExecutionCommand = "GetSupportedInterfaceVersions"
+	// GetCapabilitiesCommand returns the capabilities of the provider.
+	GetCapabilitiesCommand ExecutionCommand = "GetCapabilities"
+	// CreateInstancesCommand creates several instances. The bootstrap params are passed
+	// in as a JSON array on stdin.
+	CreateInstancesCommand ExecutionCommand = "CreateInstances"
+	// DeleteInstancesCommand deletes several instances. The instance IDs are passed in
+	// as a JSON array on stdin.
+	DeleteInstancesCommand ExecutionCommand = "DeleteInstances"
+	// ReconcileCommand compares the instances GARM expects in a pool with the ones that
+	// exist in the provider. The expected instances are passed in as a JSON array on stdin.
+	ReconcileCommand ExecutionCommand = "Reconcile"
+	// ValidateExtraSpecsCommand validates the extra specs of a pool. The extra specs are
+	// passed in as a JSON object on stdin.
+	ValidateExtraSpecsCommand ExecutionCommand = "ValidateExtraSpecs"
 )
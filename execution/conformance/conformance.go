@@ -0,0 +1,296 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package conformance holds a suite of tests that verify an execution.ExternalProvider
+// implementation follows the contract GARM expects. Provider authors can run it from
+// their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		provider := newTestProvider(t)
+//		conformance.Run(t, provider, conformance.Config{
+//			BootstrapParams: func(name, poolID string) params.BootstrapInstance { ... },
+//		})
+//	}
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/client"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+// BootstrapParamsFunc returns the bootstrap params used to create an instance with the
+// given name in the given pool.
+type BootstrapParamsFunc func(name, poolID string) params.BootstrapInstance
+
+// Config customizes the conformance suite.
+type Config struct {
+	// BootstrapParams returns the bootstrap params used to create instances. Most
+	// providers will need to set a valid flavor and image. If nil, a minimal set of
+	// Linux/amd64 bootstrap params is used.
+	BootstrapParams BootstrapParamsFunc
+	// PoolID is the pool in which instances are created. A second pool, used to test
+	// filtering, is derived from it.
+	PoolID string
+	// Timeout is the maximum amount of time an individual scenario is allowed to run.
+	Timeout time.Duration
+	// PollInterval is the interval at which the suite polls the provider while waiting
+	// for an instance to reach a status.
+	PollInterval time.Duration
+	// SkipStartStop skips the scenarios that start and stop instances, for providers
+	// that don't support it.
+	SkipStartStop bool
+}
+
+func (c Config) withDefaults() Config {
+	if c.BootstrapParams == nil {
+		c.BootstrapParams = DefaultBootstrapParams
+	}
+	if c.PoolID == "" {
+		c.PoolID = "conformance-pool"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Minute
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = 5 * time.Second
+	}
+	return c
+}
+
+// DefaultBootstrapParams returns a minimal set of Linux/amd64 bootstrap params.
+func DefaultBootstrapParams(name, poolID string) params.BootstrapInstance {
+	return params.BootstrapInstance{
+		Name:   name,
+		PoolID: poolID,
+		OSType: params.Linux,
+		OSArch: params.Amd64,
+	}
+}
+
+// Suite holds the state shared by the scenarios.
+type Suite struct {
+	Provider execution.ExternalProvider
+	Config   Config
+
+	// binary is set when the provider is a client.Provider executing a provider binary,
+	// in which case the exit codes of the binary are checked.
+	binary bool
+}
+
+// Scenario is a single conformance test.
+type Scenario struct {
+	// Name is the name of the scenario, used as the name of the subtest.
+	Name string
+	// StartStop marks scenarios that need Start and Stop support.
+	StartStop bool
+	// Run executes the scenario.
+	Run func(ctx context.Context, t *testing.T, s *Suite)
+}
+
+// Scenarios is the list of scenarios executed by Run.
+var Scenarios = []Scenario{
+	{Name: "CreateAndGetInstance", Run: testCreateAndGetInstance},
+	{Name: "GetMissingInstanceReturnsNotFound", Run: testGetMissingInstance},
+	{Name: "DeleteInstanceIsIdempotent", Run: testDeleteInstanceIsIdempotent},
+	{Name: "ListInstancesFiltersByPool", Run: testListInstancesFiltersByPool},
+	{Name: "StopAndStartInstance", StartStop: true, Run: testStopAndStartInstance},
+}
+
+// Run executes all conformance scenarios against the given provider.
+func Run(t *testing.T, provider execution.ExternalProvider, cfg Config) {
+	t.Helper()
+
+	suite := &Suite{
+		Provider: provider,
+		Config:   cfg.withDefaults(),
+	}
+	suite.run(t)
+}
+
+// RunBinary executes all conformance scenarios against a provider binary, using the
+// same protocol GARM uses. On top of the errors decoded by the client, the exit codes
+// of the binary are checked (eg: 30 for a missing instance).
+func RunBinary(t *testing.T, providerCfg client.Config, cfg Config) {
+	t.Helper()
+
+	provider, err := client.NewProvider(providerCfg)
+	require.NoError(t, err, "failed to create provider client")
+
+	suite := &Suite{
+		Provider: provider,
+		Config:   cfg.withDefaults(),
+		binary:   true,
+	}
+	suite.run(t)
+}
+
+func (s *Suite) run(t *testing.T) {
+	t.Helper()
+
+	for _, scenario := range Scenarios {
+		scenario := scenario
+		t.Run(scenario.Name, func(t *testing.T) {
+			if scenario.StartStop && s.Config.SkipStartStop {
+				t.Skip("start and stop not supported by provider")
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), s.Config.Timeout)
+			defer cancel()
+			scenario.Run(ctx, t, s)
+		})
+	}
+}
+
+// createInstance creates an instance and schedules its removal at the end of the test.
+func (s *Suite) createInstance(ctx context.Context, t *testing.T, name, poolID string) params.ProviderInstance {
+	t.Helper()
+
+	instance, err := s.Provider.CreateInstance(ctx, s.Config.BootstrapParams(name, poolID))
+	require.NoError(t, err, "CreateInstance must not fail")
+	require.NotEmpty(t, instance.ProviderID, "CreateInstance must return the provider ID")
+	require.Equal(t, name, instance.Name, "CreateInstance must return the instance name")
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Config.Timeout)
+		defer cancel()
+		if err := s.Provider.DeleteInstance(ctx, instance.ProviderID); err != nil && !isNotFound(err) {
+			t.Errorf("failed to clean up instance %s: %s", instance.ProviderID, err)
+		}
+	})
+	return instance
+}
+
+// waitForStatus polls the provider until the instance reaches the given status.
+func (s *Suite) waitForStatus(ctx context.Context, t *testing.T, instanceID string, status params.InstanceStatus) params.ProviderInstance {
+	t.Helper()
+
+	var (
+		instance params.ProviderInstance
+		err      error
+	)
+	for {
+		instance, err = s.Provider.GetInstance(ctx, instanceID)
+		require.NoError(t, err, "GetInstance must not fail")
+		if instance.Status == status {
+			return instance
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for instance %s to reach status %s (last status: %s)", instanceID, status, instance.Status)
+		case <-time.After(s.Config.PollInterval):
+		}
+	}
+}
+
+// requireExitCode checks that the provider binary exited with the given code. For
+// providers that run in process, the exit code Main() would use is checked instead.
+func (s *Suite) requireExitCode(t *testing.T, err error, code int) {
+	t.Helper()
+
+	if !s.binary {
+		require.Equal(t, code, execution.ResolveErrorToExitCode(err))
+		return
+	}
+
+	var exitErr *client.ExitError
+	require.True(t, errors.As(err, &exitErr), "the provider binary must exit with a non-zero exit code, got: %s", err)
+	require.Equal(t, code, exitErr.ExitCode, "the provider binary must exit with %d, got: %s", code, err)
+}
+
+func isNotFound(err error) bool {
+	var notFoundErr *gErrors.NotFoundError
+	return errors.As(err, &notFoundErr)
+}
+
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("garm-conformance-%s-%d", prefix, time.Now().UnixNano())
+}
+
+func testCreateAndGetInstance(ctx context.Context, t *testing.T, s *Suite) {
+	name := uniqueName("create")
+	created := s.createInstance(ctx, t, name, s.Config.PoolID)
+
+	instance, err := s.Provider.GetInstance(ctx, created.ProviderID)
+	require.NoError(t, err, "GetInstance must find a newly created instance")
+	require.Equal(t, created.ProviderID, instance.ProviderID)
+	require.Equal(t, name, instance.Name)
+	require.NotEqual(t, params.InstanceStatusUnknown, instance.Status, "GetInstance must return a known status")
+	require.NotEmpty(t, instance.Status, "GetInstance must return a status")
+}
+
+func testGetMissingInstance(ctx context.Context, t *testing.T, s *Suite) {
+	_, err := s.Provider.GetInstance(ctx, uniqueName("missing"))
+	require.Error(t, err, "GetInstance must fail for missing instances")
+	require.True(t, isNotFound(err), "GetInstance must return a NotFoundError for missing instances, got: %s", err)
+	s.requireExitCode(t, err, execution.ExitCodeNotFound)
+}
+
+func testDeleteInstanceIsIdempotent(ctx context.Context, t *testing.T, s *Suite) {
+	created := s.createInstance(ctx, t, uniqueName("delete"), s.Config.PoolID)
+
+	require.NoError(t, s.Provider.DeleteInstance(ctx, created.ProviderID), "DeleteInstance must not fail")
+	require.NoError(t, s.Provider.DeleteInstance(ctx, created.ProviderID), "DeleteInstance must not fail for an already deleted instance")
+	require.NoError(t, s.Provider.DeleteInstance(ctx, uniqueName("missing")), "DeleteInstance must not fail for a missing instance")
+
+	instance, err := s.Provider.GetInstance(ctx, created.ProviderID)
+	if err == nil {
+		// Some providers delete instances asynchronously.
+		require.Contains(t, []params.InstanceStatus{params.InstanceDeleting, params.InstancePendingDelete}, instance.Status,
+			"GetInstance must return NotFound or a deleting status for deleted instances")
+		return
+	}
+	require.True(t, isNotFound(err), "GetInstance must return a NotFoundError for deleted instances, got: %s", err)
+}
+
+func testListInstancesFiltersByPool(ctx context.Context, t *testing.T, s *Suite) {
+	otherPool := s.Config.PoolID + "-other"
+	first := s.createInstance(ctx, t, uniqueName("list"), s.Config.PoolID)
+	second := s.createInstance(ctx, t, uniqueName("list-other"), otherPool)
+
+	instances, err := s.Provider.ListInstances(ctx, s.Config.PoolID)
+	require.NoError(t, err, "ListInstances must not fail")
+
+	var found bool
+	for _, instance := range instances {
+		require.NotEqual(t, second.ProviderID, instance.ProviderID, "ListInstances must only return instances from the requested pool")
+		if instance.ProviderID == first.ProviderID {
+			found = true
+		}
+	}
+	require.True(t, found, "ListInstances must return instances in the requested pool")
+
+	instances, err = s.Provider.ListInstances(ctx, uniqueName("empty-pool"))
+	require.NoError(t, err, "ListInstances must not fail for an empty pool")
+	require.Empty(t, instances, "ListInstances must return an empty list for an empty pool")
+}
+
+func testStopAndStartInstance(ctx context.Context, t *testing.T, s *Suite) {
+	created := s.createInstance(ctx, t, uniqueName("startstop"), s.Config.PoolID)
+	s.waitForStatus(ctx, t, created.ProviderID, params.InstanceRunning)
+
+	require.NoError(t, s.Provider.Stop(ctx, created.ProviderID, true), "Stop must not fail")
+	s.waitForStatus(ctx, t, created.ProviderID, params.InstanceStopped)
+
+	require.NoError(t, s.Provider.Start(ctx, created.ProviderID), "Start must not fail")
+	s.waitForStatus(ctx, t, created.ProviderID, params.InstanceRunning)
+}
@@ -0,0 +1,275 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/client"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryProvider struct {
+	mux       sync.Mutex
+	instances map[string]params.ProviderInstance
+	pools     map[string]string
+
+	// plainErrors makes GetInstance return a plain error for missing instances, which
+	// does not conform to the contract.
+	plainErrors bool
+}
+
+func newMemoryProvider() *memoryProvider {
+	return &memoryProvider{
+		instances: map[string]params.ProviderInstance{},
+		pools:     map[string]string{},
+	}
+}
+
+func (m *memoryProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	instance := params.ProviderInstance{
+		ProviderID: "id-" + bootstrapParams.Name,
+		Name:       bootstrapParams.Name,
+		OSType:     bootstrapParams.OSType,
+		OSArch:     bootstrapParams.OSArch,
+		Status:     params.InstanceRunning,
+	}
+	m.instances[instance.ProviderID] = instance
+	m.pools[instance.ProviderID] = bootstrapParams.PoolID
+	return instance, nil
+}
+
+func (m *memoryProvider) DeleteInstance(ctx context.Context, instance string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	delete(m.instances, instance)
+	delete(m.pools, instance)
+	return nil
+}
+
+func (m *memoryProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	inst, ok := m.instances[instance]
+	if !ok {
+		if m.plainErrors {
+			return params.ProviderInstance{}, fmt.Errorf("instance %s not found", instance)
+		}
+		return params.ProviderInstance{}, gErrors.NewNotFoundError("instance %s not found", instance)
+	}
+	return inst, nil
+}
+
+func (m *memoryProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	ret := []params.ProviderInstance{}
+	for id, inst := range m.instances {
+		if m.pools[id] == poolID {
+			ret = append(ret, inst)
+		}
+	}
+	return ret, nil
+}
+
+func (m *memoryProvider) RemoveAllInstances(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryProvider) setStatus(instance string, status params.InstanceStatus) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	inst, ok := m.instances[instance]
+	if !ok {
+		return gErrors.NewNotFoundError("instance %s not found", instance)
+	}
+	inst.Status = status
+	m.instances[instance] = inst
+	return nil
+}
+
+func (m *memoryProvider) Stop(ctx context.Context, instance string, force bool) error {
+	return m.setStatus(instance, params.InstanceStopped)
+}
+
+func (m *memoryProvider) Start(ctx context.Context, instance string) error {
+	return m.setStatus(instance, params.InstanceRunning)
+}
+
+// memoryState is the state of a memoryProvider, saved on disk when the test binary runs
+// as a provider binary, as each command is executed in a new process.
+type memoryState struct {
+	Instances map[string]params.ProviderInstance `json:"instances"`
+	Pools     map[string]string                  `json:"pools"`
+}
+
+// fileProvider is a memoryProvider that saves its state to a file after each change.
+type fileProvider struct {
+	*memoryProvider
+	path string
+}
+
+func loadFileProvider(path string) (*fileProvider, error) {
+	provider := &fileProvider{
+		memoryProvider: newMemoryProvider(),
+		path:           path,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return provider, err
+	}
+
+	var state memoryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	provider.instances = state.Instances
+	provider.pools = state.Pools
+	return provider, nil
+}
+
+func (f *fileProvider) save(err error) error {
+	if err != nil {
+		return err
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	data, err := json.Marshal(memoryState{Instances: f.instances, Pools: f.pools})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.path, data, 0o600)
+}
+
+func (f *fileProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	instance, err := f.memoryProvider.CreateInstance(ctx, bootstrapParams)
+	return instance, f.save(err)
+}
+
+func (f *fileProvider) DeleteInstance(ctx context.Context, instance string) error {
+	return f.save(f.memoryProvider.DeleteInstance(ctx, instance))
+}
+
+func (f *fileProvider) Stop(ctx context.Context, instance string, force bool) error {
+	return f.save(f.memoryProvider.Stop(ctx, instance, force))
+}
+
+func (f *fileProvider) Start(ctx context.Context, instance string) error {
+	return f.save(f.memoryProvider.Start(ctx, instance))
+}
+
+// TestMain runs the test binary as a provider binary, backed by a fileProvider that uses
+// the provider config file as its state, when CONFORMANCE_TEST_HELPER is set. The value
+// selects how the provider behaves:
+//   - conforming: follows the contract.
+//   - plain-errors: returns a plain error for missing instances.
+//   - wrong-exit-code: reports missing instances with a NotFound error document, but
+//     exits with 1.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("CONFORMANCE_TEST_HELPER"); mode != "" {
+		runHelperProvider(mode)
+	}
+	os.Exit(m.Run())
+}
+
+func runHelperProvider(mode string) {
+	stateFile := os.Getenv("GARM_PROVIDER_CONFIG_FILE")
+	if mode == "wrong-exit-code" && os.Getenv("GARM_COMMAND") == string(execution.GetInstanceCommand) {
+		provider, err := loadFileProvider(stateFile)
+		if err == nil {
+			_, err = provider.GetInstance(context.Background(), os.Getenv("GARM_INSTANCE_ID"))
+		}
+		if err != nil {
+			_ = execution.WriteErrorDocument(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	execution.Main(func(ctx context.Context, env execution.Environment) (execution.ExternalProvider, error) {
+		provider, err := loadFileProvider(env.ProviderConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		provider.plainErrors = mode == "plain-errors"
+		return provider, nil
+	})
+}
+
+func testConfig() Config {
+	return Config{
+		Timeout:      10 * time.Second,
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+func binaryConfig(t *testing.T, mode string) client.Config {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(stateFile, []byte{}, 0o600))
+
+	return client.Config{
+		ProviderBinary:     os.Args[0],
+		ProviderConfigFile: stateFile,
+		ControllerID:       "test-controller",
+		Environment:        []string{"CONFORMANCE_TEST_HELPER=" + mode},
+	}
+}
+
+func TestConformance(t *testing.T) {
+	Run(t, newMemoryProvider(), testConfig())
+}
+
+func TestConformanceBinary(t *testing.T) {
+	RunBinary(t, binaryConfig(t, "conforming"), testConfig())
+}
+
+// TestConformanceNonConforming runs the suite against non-conforming providers, in a child
+// process, and expects it to fail.
+func TestConformanceNonConforming(t *testing.T) {
+	switch mode := os.Getenv("CONFORMANCE_NON_CONFORMING"); mode {
+	case "":
+	case "in-process":
+		provider := newMemoryProvider()
+		provider.plainErrors = true
+		Run(t, provider, testConfig())
+		return
+	default:
+		RunBinary(t, binaryConfig(t, mode), testConfig())
+		return
+	}
+
+	tests := map[string]string{
+		"in-process":      "GetInstance must return a NotFoundError for missing instances",
+		"plain-errors":    "GetInstance must return a NotFoundError for missing instances",
+		"wrong-exit-code": "the provider binary must exit with 30",
+	}
+	for mode, expected := range tests {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestConformanceNonConforming$", "-test.v")
+		cmd.Env = append(os.Environ(), "CONFORMANCE_NON_CONFORMING="+mode)
+		out, err := cmd.CombinedOutput()
+
+		var exitErr *exec.ExitError
+		require.True(t, errors.As(err, &exitErr), "the suite must fail for mode %s: %s", mode, out)
+		require.Contains(t, string(out), expected, "mode %s", mode)
+		require.Contains(t, string(out), "--- PASS: TestConformanceNonConforming/CreateAndGetInstance", "mode %s", mode)
+	}
+}
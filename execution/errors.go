@@ -0,0 +1,214 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+)
+
+const (
+	// ExitCodeNotFound is an exit code that indicates a Not Found error
+	ExitCodeNotFound int = 30
+	// ExitCodeDuplicate is an exit code that indicates a duplicate error
+	ExitCodeDuplicate int = 31
+	// ExitCodeUnauthorized is an exit code that indicates an unauthorized error
+	ExitCodeUnauthorized int = 32
+	// ExitCodeBadRequest is an exit code that indicates a bad request error
+	ExitCodeBadRequest int = 33
+	// ExitCodeConflict is an exit code that indicates a conflict error
+	ExitCodeConflict int = 34
+	// ExitCodeTimeout is an exit code that indicates a timeout
+	ExitCodeTimeout int = 35
+	// ExitCodeProviderError is an exit code that indicates an error returned by the IaaS
+	ExitCodeProviderError int = 36
+	// ExitCodeMissingSecret is an exit code that indicates a missing secret error
+	ExitCodeMissingSecret int = 37
+)
+
+// ErrorClass identifies the type of error a provider returned.
+type ErrorClass string
+
+const (
+	ErrorClassNotFound      ErrorClass = "NotFound"
+	ErrorClassDuplicate     ErrorClass = "Duplicate"
+	ErrorClassUnauthorized  ErrorClass = "Unauthorized"
+	ErrorClassBadRequest    ErrorClass = "BadRequest"
+	ErrorClassConflict      ErrorClass = "Conflict"
+	ErrorClassTimeout       ErrorClass = "Timeout"
+	ErrorClassProviderError ErrorClass = "ProviderError"
+	ErrorClassMissingSecret ErrorClass = "MissingSecret"
+	ErrorClassUnknown       ErrorClass = "Unknown"
+)
+
+// ErrorDocument is the machine readable representation of an error, written by
+// providers to stderr.
+type ErrorDocument struct {
+	// Class is the type of the error.
+	Class ErrorClass `json:"class"`
+	// Message is the human readable error message.
+	Message string `json:"message"`
+	// ExitCode is the exit code the provider exited with.
+	ExitCode int `json:"exit_code"`
+	// Retryable indicates whether or not the operation can be retried.
+	Retryable bool `json:"retryable"`
+	// ProviderFault holds the raw fault details returned by the IaaS, if any.
+	ProviderFault []byte `json:"provider_fault,omitempty"`
+}
+
+// ResolveErrorToClass returns the error class and exit code of the given error.
+func ResolveErrorToClass(err error) (ErrorClass, int) {
+	if err == nil {
+		return "", 0
+	}
+
+	var (
+		notFoundErr      *gErrors.NotFoundError
+		duplicateErr     *gErrors.DuplicateUserError
+		unauthorizedErr  *gErrors.UnauthorizedError
+		badRequestErr    *gErrors.BadRequestError
+		conflictErr      *gErrors.ConflictError
+		providerErr      *gErrors.ProviderError
+		missingSecretErr *gErrors.MissingSecretError
+	)
+
+	switch {
+	case errors.As(err, &notFoundErr):
+		return ErrorClassNotFound, ExitCodeNotFound
+	case errors.As(err, &duplicateErr):
+		return ErrorClassDuplicate, ExitCodeDuplicate
+	case errors.As(err, &unauthorizedErr):
+		return ErrorClassUnauthorized, ExitCodeUnauthorized
+	case errors.As(err, &badRequestErr):
+		return ErrorClassBadRequest, ExitCodeBadRequest
+	case errors.As(err, &conflictErr):
+		return ErrorClassConflict, ExitCodeConflict
+	case errors.Is(err, gErrors.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout, ExitCodeTimeout
+	case errors.As(err, &providerErr):
+		return ErrorClassProviderError, ExitCodeProviderError
+	case errors.As(err, &missingSecretErr):
+		return ErrorClassMissingSecret, ExitCodeMissingSecret
+	}
+	return ErrorClassUnknown, 1
+}
+
+func ResolveErrorToExitCode(err error) int {
+	_, code := ResolveErrorToClass(err)
+	return code
+}
+
+// ResolveExitCodeToClass returns the error class that corresponds to the given exit code.
+func ResolveExitCodeToClass(code int) ErrorClass {
+	switch code {
+	case 0:
+		return ""
+	case ExitCodeNotFound:
+		return ErrorClassNotFound
+	case ExitCodeDuplicate:
+		return ErrorClassDuplicate
+	case ExitCodeUnauthorized:
+		return ErrorClassUnauthorized
+	case ExitCodeBadRequest:
+		return ErrorClassBadRequest
+	case ExitCodeConflict:
+		return ErrorClassConflict
+	case ExitCodeTimeout:
+		return ErrorClassTimeout
+	case ExitCodeProviderError:
+		return ErrorClassProviderError
+	case ExitCodeMissingSecret:
+		return ErrorClassMissingSecret
+	}
+	return ErrorClassUnknown
+}
+
+// IsRetryable returns true if the operation that returned the given error may
+// succeed if attempted again.
+func IsRetryable(err error) bool {
+	class, _ := ResolveErrorToClass(err)
+	switch class {
+	case ErrorClassTimeout, ErrorClassProviderError:
+		return true
+	}
+	return false
+}
+
+// NewErrorDocument returns the machine readable representation of the given error.
+func NewErrorDocument(err error) ErrorDocument {
+	if err == nil {
+		return ErrorDocument{}
+	}
+
+	class, code := ResolveErrorToClass(err)
+	doc := ErrorDocument{
+		Class:     class,
+		Message:   err.Error(),
+		ExitCode:  code,
+		Retryable: IsRetryable(err),
+	}
+
+	var providerErr *gErrors.ProviderError
+	if errors.As(err, &providerErr) {
+		doc.ProviderFault = providerErr.Fault()
+	}
+	return doc
+}
+
+// WriteErrorDocument writes the machine readable representation of the given error
+// to w, as a single line of JSON.
+func WriteErrorDocument(w io.Writer, err error) error {
+	asJs, err := json.Marshal(NewErrorDocument(err))
+	if err != nil {
+		return fmt.Errorf("failed to marshal error: %w", err)
+	}
+
+	if _, err := fmt.Fprintln(w, string(asJs)); err != nil {
+		return fmt.Errorf("failed to write error: %w", err)
+	}
+	return nil
+}
+
+// Err returns an error of the type described by the error document. Where the errors
+// package defines a sentinel for the error class, the returned error wraps it, so both
+// errors.Is() and errors.As() can be used by the caller.
+func (d ErrorDocument) Err() error {
+	switch d.Class {
+	case "":
+		return nil
+	case ErrorClassNotFound:
+		return fmt.Errorf("%w: %s", gErrors.ErrNotFound, d.Message)
+	case ErrorClassDuplicate:
+		return fmt.Errorf("%w: %s", gErrors.ErrDuplicateEntity, d.Message)
+	case ErrorClassUnauthorized:
+		return fmt.Errorf("%w: %s", gErrors.ErrUnauthorized, d.Message)
+	case ErrorClassBadRequest:
+		return fmt.Errorf("%w: %s", gErrors.ErrBadRequest, d.Message)
+	case ErrorClassConflict:
+		return gErrors.NewConflictError("%s", d.Message)
+	case ErrorClassTimeout:
+		return fmt.Errorf("%w: %s", gErrors.ErrTimeout, d.Message)
+	case ErrorClassProviderError:
+		return gErrors.NewProviderErrorWithFault(d.ProviderFault, "%s", d.Message)
+	case ErrorClassMissingSecret:
+		return gErrors.NewMissingSecretError("%s", d.Message)
+	}
+	return fmt.Errorf("%s", d.Message)
+}
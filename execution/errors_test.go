@@ -0,0 +1,71 @@
+package execution
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveErrorToExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{name: "nil", err: nil, code: 0},
+		{name: "not found sentinel", err: gErrors.ErrNotFound, code: ExitCodeNotFound},
+		{name: "not found", err: gErrors.NewNotFoundError("instance %s not found", "test"), code: ExitCodeNotFound},
+		{name: "duplicate", err: gErrors.ErrDuplicateEntity, code: ExitCodeDuplicate},
+		{name: "unauthorized", err: gErrors.NewUnauthorizedError("bad credentials"), code: ExitCodeUnauthorized},
+		{name: "bad request", err: gErrors.NewBadRequestError("invalid flavor"), code: ExitCodeBadRequest},
+		{name: "conflict", err: gErrors.NewConflictError("conflict"), code: ExitCodeConflict},
+		{name: "timeout", err: gErrors.ErrTimeout, code: ExitCodeTimeout},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, code: ExitCodeTimeout},
+		{name: "provider error", err: gErrors.NewProviderError("quota exceeded"), code: ExitCodeProviderError},
+		{name: "missing secret", err: gErrors.NewMissingSecretError("missing secret"), code: ExitCodeMissingSecret},
+		{name: "wrapped", err: fmt.Errorf("failed to get instance: %w", gErrors.ErrNotFound), code: ExitCodeNotFound},
+		{name: "unknown", err: fmt.Errorf("bogus"), code: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.code, ResolveErrorToExitCode(tc.err))
+		})
+	}
+}
+
+func TestNewErrorDocument(t *testing.T) {
+	err := fmt.Errorf("failed to create instance: %w", gErrors.NewProviderErrorWithFault([]byte("fault"), "quota exceeded"))
+
+	doc := NewErrorDocument(err)
+	require.Equal(t, ErrorDocument{
+		Class:         ErrorClassProviderError,
+		Message:       "failed to create instance: quota exceeded",
+		ExitCode:      ExitCodeProviderError,
+		Retryable:     true,
+		ProviderFault: []byte("fault"),
+	}, doc)
+
+	doc = NewErrorDocument(gErrors.NewUnauthorizedError("bad credentials"))
+	require.Equal(t, ErrorClassUnauthorized, doc.Class)
+	require.False(t, doc.Retryable)
+}
+
+func TestWriteErrorDocument(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteErrorDocument(&buf, gErrors.ErrTimeout))
+
+	var doc ErrorDocument
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	require.Equal(t, ErrorDocument{
+		Class:     ErrorClassTimeout,
+		Message:   "timed out",
+		ExitCode:  ExitCodeTimeout,
+		Retryable: true,
+	}, doc)
+}
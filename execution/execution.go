@@ -18,10 +18,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	gErrors "github.com/cloudbase/garm-provider-common/errors"
 	"github.com/cloudbase/garm-provider-common/params"
@@ -29,25 +31,6 @@ import (
 	"github.com/mattn/go-isatty"
 )
 
-const (
-	// ExitCodeNotFound is an exit code that indicates a Not Found error
-	ExitCodeNotFound int = 30
-	// ExitCodeDuplicate is an exit code that indicates a duplicate error
-	ExitCodeDuplicate int = 31
-)
-
-func ResolveErrorToExitCode(err error) int {
-	if err != nil {
-		if errors.Is(err, gErrors.ErrNotFound) {
-			return ExitCodeNotFound
-		} else if errors.Is(err, gErrors.ErrDuplicateEntity) {
-			return ExitCodeDuplicate
-		}
-		return 1
-	}
-	return 0
-}
-
 func GetEnvironment() (Environment, error) {
 	env := Environment{
 		Command:            ExecutionCommand(os.Getenv("GARM_COMMAND")),
@@ -55,29 +38,126 @@ func GetEnvironment() (Environment, error) {
 		PoolID:             os.Getenv("GARM_POOL_ID"),
 		ProviderConfigFile: os.Getenv("GARM_PROVIDER_CONFIG_FILE"),
 		InstanceID:         os.Getenv("GARM_INSTANCE_ID"),
+		InterfaceVersion:   os.Getenv("GARM_INTERFACE_VERSION"),
+	}
+
+	if env.InterfaceVersion == "" {
+		env.InterfaceVersion = DefaultInterfaceVersion
+	}
+
+	if val := os.Getenv("GARM_STOP_FORCE"); val != "" {
+		force, err := strconv.ParseBool(val)
+		if err != nil {
+			return Environment{}, fmt.Errorf("failed to parse GARM_STOP_FORCE: %w", err)
+		}
+		env.GracefulStop = !force
+	}
+
+	if val := os.Getenv("GARM_STOP_GRACEFUL_TIMEOUT"); val != "" {
+		timeout, err := time.ParseDuration(val)
+		if err != nil {
+			return Environment{}, fmt.Errorf("failed to parse GARM_STOP_GRACEFUL_TIMEOUT: %w", err)
+		}
+		env.GracefulStopTimeout = timeout
+	}
+
+	env.ListOptions = ListInstancesOptions{
+		Status:            params.InstanceStatus(os.Getenv("GARM_LIST_STATUS")),
+		ContinuationToken: os.Getenv("GARM_LIST_CONTINUATION_TOKEN"),
+	}
+	if val := os.Getenv("GARM_LIST_PAGE_SIZE"); val != "" {
+		pageSize, err := strconv.Atoi(val)
+		if err != nil {
+			return Environment{}, fmt.Errorf("failed to parse GARM_LIST_PAGE_SIZE: %w", err)
+		}
+		env.ListOptions.PageSize = pageSize
+	}
+	env.OutputFormat = OutputFormat(os.Getenv("GARM_LIST_OUTPUT"))
+
+	if val := os.Getenv("GARM_BATCH_CONCURRENCY"); val != "" {
+		concurrency, err := strconv.Atoi(val)
+		if err != nil {
+			return Environment{}, fmt.Errorf("failed to parse GARM_BATCH_CONCURRENCY: %w", err)
+		}
+		env.BatchConcurrency = concurrency
+	}
+
+	if val := os.Getenv("GARM_RECONCILE_DELETE_ORPHANS"); val != "" {
+		deleteOrphans, err := strconv.ParseBool(val)
+		if err != nil {
+			return Environment{}, fmt.Errorf("failed to parse GARM_RECONCILE_DELETE_ORPHANS: %w", err)
+		}
+		env.DeleteOrphans = deleteOrphans
+	}
+
+	if val := os.Getenv("GARM_RECONCILE_ALLOW_DELETE_ALL"); val != "" {
+		allowDeleteAll, err := strconv.ParseBool(val)
+		if err != nil {
+			return Environment{}, fmt.Errorf("failed to parse GARM_RECONCILE_ALLOW_DELETE_ALL: %w", err)
+		}
+		env.AllowDeleteAll = allowDeleteAll
+	}
+
+	codec, err := getCommandCodec(env.Command, env.InterfaceVersion)
+	if err != nil {
+		return Environment{}, err
+	}
+
+	if err := codec.decodeEnvironment(&env, os.Getenv); err != nil {
+		return Environment{}, err
 	}
 
 	// If this is a CreateInstance command, we need to get the bootstrap params
 	// from stdin
-	if env.Command == CreateInstanceCommand {
-		if isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd()) {
-			return Environment{}, fmt.Errorf("%s requires data passed into stdin", CreateInstanceCommand)
+	switch env.Command {
+	case CreateInstanceCommand:
+		data, err := readStdin(env.Command)
+		if err != nil {
+			return Environment{}, err
 		}
 
-		var data bytes.Buffer
-		if _, err := io.Copy(&data, os.Stdin); err != nil {
-			return Environment{}, fmt.Errorf("failed to copy bootstrap params")
+		bootstrapParams, err := codec.decodeBootstrapParams(data)
+		if err != nil {
+			return Environment{}, err
 		}
-
-		if data.Len() == 0 {
-			return Environment{}, fmt.Errorf("%s requires data passed into stdin", CreateInstanceCommand)
+		env.BootstrapParams = bootstrapParams
+	case CreateInstancesCommand:
+		data, err := readStdin(env.Command)
+		if err != nil {
+			return Environment{}, err
 		}
 
-		var bootstrapParams params.BootstrapInstance
-		if err := json.Unmarshal(data.Bytes(), &bootstrapParams); err != nil {
+		if err := json.Unmarshal(data, &env.BatchBootstrapParams); err != nil {
 			return Environment{}, fmt.Errorf("failed to decode instance params: %w", err)
 		}
-		env.BootstrapParams = bootstrapParams
+	case DeleteInstancesCommand:
+		data, err := readStdin(env.Command)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		if err := json.Unmarshal(data, &env.BatchInstanceIDs); err != nil {
+			return Environment{}, fmt.Errorf("failed to decode instance IDs: %w", err)
+		}
+	case ReconcileCommand:
+		data, err := readStdin(env.Command)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		if err := json.Unmarshal(data, &env.ExpectedInstances); err != nil {
+			return Environment{}, fmt.Errorf("failed to decode expected instances: %w", err)
+		}
+	case ValidateExtraSpecsCommand:
+		data, err := readStdin(env.Command)
+		if err != nil {
+			return Environment{}, err
+		}
+
+		if !json.Valid(data) {
+			return Environment{}, fmt.Errorf("extra specs are not valid json")
+		}
+		env.ExtraSpecs = data
 	}
 
 	if err := env.Validate(); err != nil {
@@ -87,13 +167,166 @@ func GetEnvironment() (Environment, error) {
 	return env, nil
 }
 
+func readStdin(command ExecutionCommand) ([]byte, error) {
+	if isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return nil, fmt.Errorf("%s requires data passed into stdin", command)
+	}
+
+	var data bytes.Buffer
+	if _, err := io.Copy(&data, os.Stdin); err != nil {
+		return nil, fmt.Errorf("failed to copy stdin data")
+	}
+
+	if data.Len() == 0 {
+		return nil, fmt.Errorf("%s requires data passed into stdin", command)
+	}
+	return data.Bytes(), nil
+}
+
 type Environment struct {
-	Command            ExecutionCommand
-	ControllerID       string
-	PoolID             string
-	ProviderConfigFile string
-	InstanceID         string
-	BootstrapParams    params.BootstrapInstance
+	Command            ExecutionCommand         `json:"command"`
+	ControllerID       string                   `json:"controller_id,omitempty"`
+	PoolID             string                   `json:"pool_id,omitempty"`
+	ProviderConfigFile string                   `json:"provider_config_file,omitempty"`
+	InstanceID         string                   `json:"instance_id,omitempty"`
+	BootstrapParams    params.BootstrapInstance `json:"bootstrap_params"`
+	// InterfaceVersion is the version of the interface GARM expects the provider to speak.
+	InterfaceVersion string `json:"interface_version,omitempty"`
+	// ExtraSpecs holds the extra specs of the pool. This is only set by GARM starting with
+	// interface version v0.1.1.
+	ExtraSpecs json.RawMessage `json:"extra_specs,omitempty"`
+	// GracefulStop indicates that the instance should be shut down gracefully by the
+	// StopInstance command. It is set when GARM_STOP_FORCE is false. By default,
+	// instances are forcefully stopped.
+	GracefulStop bool `json:"graceful_stop,omitempty"`
+	// GracefulStopTimeout is the amount of time to wait for an instance to stop gracefully
+	// before stopping it forcefully. If zero, the instance is not forcefully stopped. It is
+	// set from GARM_STOP_GRACEFUL_TIMEOUT.
+	GracefulStopTimeout time.Duration `json:"graceful_stop_timeout,omitempty"`
+	// ListOptions holds the status filter and pagination options of the ListInstances
+	// command. They are set from GARM_LIST_STATUS, GARM_LIST_PAGE_SIZE and
+	// GARM_LIST_CONTINUATION_TOKEN.
+	ListOptions ListInstancesOptions `json:"list_options"`
+	// OutputFormat is the format of the ListInstances result. It is set from
+	// GARM_LIST_OUTPUT. Defaults to OutputFormatJSON.
+	OutputFormat OutputFormat `json:"output_format,omitempty"`
+	// BatchBootstrapParams holds the bootstrap params of the CreateInstances command.
+	BatchBootstrapParams []params.BootstrapInstance `json:"batch_bootstrap_params,omitempty"`
+	// BatchInstanceIDs holds the instance IDs of the DeleteInstances command.
+	BatchInstanceIDs []string `json:"batch_instance_ids,omitempty"`
+	// BatchConcurrency is the number of items the batch commands process in parallel,
+	// for providers that don't implement BatchProvider. It is set from
+	// GARM_BATCH_CONCURRENCY. Defaults to DefaultBatchConcurrency.
+	BatchConcurrency int `json:"batch_concurrency,omitempty"`
+	// ExpectedInstances holds the instances GARM expects in the pool, for the Reconcile
+	// command.
+	ExpectedInstances []ExpectedInstance `json:"expected_instances,omitempty"`
+	// DeleteOrphans makes the Reconcile command delete the instances GARM does not expect.
+	// It is set from GARM_RECONCILE_DELETE_ORPHANS.
+	DeleteOrphans bool `json:"delete_orphans,omitempty"`
+	// AllowDeleteAll allows DeleteOrphans when no instances are expected, which deletes
+	// every instance in the pool. It is set from GARM_RECONCILE_ALLOW_DELETE_ALL.
+	AllowDeleteAll bool `json:"allow_delete_all,omitempty"`
+}
+
+// Environ returns the environment variables that need to be set when executing a provider
+// binary for this environment. This is the counterpart of GetEnvironment.
+func (e Environment) Environ() ([]string, error) {
+	codec, err := getCommandCodec(e.Command, e.InterfaceVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	interfaceVersion := e.InterfaceVersion
+	if interfaceVersion == "" {
+		interfaceVersion = DefaultInterfaceVersion
+	}
+
+	environ := []string{
+		fmt.Sprintf("GARM_COMMAND=%s", e.Command),
+		fmt.Sprintf("GARM_CONTROLLER_ID=%s", e.ControllerID),
+		fmt.Sprintf("GARM_POOL_ID=%s", e.PoolID),
+		fmt.Sprintf("GARM_PROVIDER_CONFIG_FILE=%s", e.ProviderConfigFile),
+		fmt.Sprintf("GARM_INSTANCE_ID=%s", e.InstanceID),
+		fmt.Sprintf("GARM_INTERFACE_VERSION=%s", interfaceVersion),
+		fmt.Sprintf("GARM_STOP_FORCE=%t", !e.GracefulStop),
+	}
+	if e.GracefulStopTimeout > 0 {
+		environ = append(environ, fmt.Sprintf("GARM_STOP_GRACEFUL_TIMEOUT=%s", e.GracefulStopTimeout))
+	}
+	if e.ListOptions.Status != "" {
+		environ = append(environ, fmt.Sprintf("GARM_LIST_STATUS=%s", e.ListOptions.Status))
+	}
+	if e.ListOptions.PageSize > 0 {
+		environ = append(environ, fmt.Sprintf("GARM_LIST_PAGE_SIZE=%d", e.ListOptions.PageSize))
+	}
+	if e.ListOptions.ContinuationToken != "" {
+		environ = append(environ, fmt.Sprintf("GARM_LIST_CONTINUATION_TOKEN=%s", e.ListOptions.ContinuationToken))
+	}
+	if e.OutputFormat != "" {
+		environ = append(environ, fmt.Sprintf("GARM_LIST_OUTPUT=%s", e.OutputFormat))
+	}
+	if e.BatchConcurrency > 0 {
+		environ = append(environ, fmt.Sprintf("GARM_BATCH_CONCURRENCY=%d", e.BatchConcurrency))
+	}
+	if e.DeleteOrphans {
+		environ = append(environ, "GARM_RECONCILE_DELETE_ORPHANS=true")
+	}
+	if e.AllowDeleteAll {
+		environ = append(environ, "GARM_RECONCILE_ALLOW_DELETE_ALL=true")
+	}
+	return append(environ, codec.encodeEnvironment(e)...), nil
+}
+
+// Stdin returns the data that needs to be passed into the stdin of a provider binary
+// for this environment. Only the create, DeleteInstances, Reconcile and ValidateExtraSpecs
+// commands expect data on stdin.
+func (e Environment) Stdin() ([]byte, error) {
+	switch e.Command {
+	case CreateInstanceCommand:
+		codec, err := getInterfaceCodec(e.InterfaceVersion)
+		if err != nil {
+			return nil, err
+		}
+		return codec.encodeBootstrapParams(e.BootstrapParams)
+	case CreateInstancesCommand:
+		asJs, err := json.Marshal(e.BatchBootstrapParams)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode instance params: %w", err)
+		}
+		return asJs, nil
+	case DeleteInstancesCommand:
+		asJs, err := json.Marshal(e.BatchInstanceIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode instance IDs: %w", err)
+		}
+		return asJs, nil
+	case ReconcileCommand:
+		expected := e.ExpectedInstances
+		if expected == nil {
+			expected = []ExpectedInstance{}
+		}
+		asJs, err := json.Marshal(expected)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode expected instances: %w", err)
+		}
+		return asJs, nil
+	case ValidateExtraSpecsCommand:
+		if len(e.ExtraSpecs) == 0 {
+			return []byte("{}"), nil
+		}
+		return e.ExtraSpecs, nil
+	}
+	return nil, nil
+}
+
+// DecodeResult decodes the output a provider wrote to stdout for this environment into v.
+func (e Environment) DecodeResult(data []byte, v interface{}) error {
+	codec, err := getCommandCodec(e.Command, e.InterfaceVersion)
+	if err != nil {
+		return err
+	}
+	return codec.decode(data, v)
 }
 
 func (e Environment) Validate() error {
@@ -113,11 +346,36 @@ func (e Environment) Validate() error {
 		return fmt.Errorf("missing GARM_CONTROLLER_ID")
 	}
 
+	if _, err := getCommandCodec(e.Command, e.InterfaceVersion); err != nil {
+		return err
+	}
+
+	if e.GracefulStopTimeout < 0 {
+		return fmt.Errorf("invalid graceful stop timeout: %s", e.GracefulStopTimeout)
+	}
+
+	if e.ListOptions.PageSize < 0 {
+		return fmt.Errorf("invalid page size: %d", e.ListOptions.PageSize)
+	}
+
+	switch e.OutputFormat {
+	case "", OutputFormatJSON, OutputFormatNDJSON:
+	default:
+		return fmt.Errorf("invalid output format: %s", e.OutputFormat)
+	}
+
+	if e.BatchConcurrency < 0 {
+		return fmt.Errorf("invalid batch concurrency: %d", e.BatchConcurrency)
+	}
+
 	switch e.Command {
 	case CreateInstanceCommand:
 		if e.BootstrapParams.Name == "" {
 			return fmt.Errorf("missing bootstrap params")
 		}
+		if err := e.BootstrapParams.Validate(); err != nil {
+			return fmt.Errorf("invalid bootstrap params: %w", err)
+		}
 		if e.ControllerID == "" {
 			return fmt.Errorf("missing controller ID")
 		}
@@ -129,7 +387,7 @@ func (e Environment) Validate() error {
 		if e.InstanceID == "" {
 			return fmt.Errorf("missing instance ID")
 		}
-	case ListInstancesCommand:
+	case ListInstancesCommand, ReconcileCommand:
 		if e.PoolID == "" {
 			return fmt.Errorf("missing pool ID")
 		}
@@ -137,13 +395,82 @@ func (e Environment) Validate() error {
 		if e.ControllerID == "" {
 			return fmt.Errorf("missing controller ID")
 		}
+	case CreateInstancesCommand:
+		if len(e.BatchBootstrapParams) == 0 {
+			return fmt.Errorf("missing bootstrap params")
+		}
+		for _, bootstrapParams := range e.BatchBootstrapParams {
+			if bootstrapParams.Name == "" {
+				return fmt.Errorf("missing instance name in bootstrap params")
+			}
+			if err := bootstrapParams.Validate(); err != nil {
+				return fmt.Errorf("invalid bootstrap params for instance %s: %w", bootstrapParams.Name, err)
+			}
+			if bootstrapParams.PoolID == "" {
+				return fmt.Errorf("missing pool ID for instance %s", bootstrapParams.Name)
+			}
+		}
+	case DeleteInstancesCommand:
+		if len(e.BatchInstanceIDs) == 0 {
+			return fmt.Errorf("missing instance IDs")
+		}
+		for _, instanceID := range e.BatchInstanceIDs {
+			if instanceID == "" {
+				return fmt.Errorf("empty instance ID")
+			}
+		}
+	case GetVersionCommand, GetSupportedInterfaceVersionsCommand,
+		GetCapabilitiesCommand, ValidateExtraSpecsCommand:
 	default:
 		return fmt.Errorf("unknown GARM_COMMAND: %s", e.Command)
 	}
 	return nil
 }
 
-func Run(ctx context.Context, provider ExternalProvider, env Environment) (string, error) {
+// Run executes the command described by the environment against the provider and returns
+// the output that needs to be written to stdout. The middlewares, if any, wrap the provider
+// for the duration of the command. If the provider returns an instance with a malformed
+// status or address, the command fails instead of emitting it, even though the instance
+// may exist in the provider (eg: after a create).
+func Run(ctx context.Context, baseProvider ExternalProvider, env Environment, middlewares ...Middleware) (string, error) {
+	var out strings.Builder
+	if err := RunTo(ctx, baseProvider, env, &out, middlewares...); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// RunTo is similar to Run, but writes the output to w. This allows the ListInstances
+// command to stream its result in NDJSON mode. If an error is returned, w may hold
+// partial output.
+func RunTo(ctx context.Context, baseProvider ExternalProvider, env Environment, w io.Writer, middlewares ...Middleware) error {
+	ret, err := runCommand(ctx, baseProvider, env, w, middlewares...)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, ret); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+	return nil
+}
+
+func runCommand(ctx context.Context, baseProvider ExternalProvider, env Environment, w io.Writer, middlewares ...Middleware) (string, error) {
+	codec, err := getCommandCodec(env.Command, env.InterfaceVersion)
+	if err != nil {
+		return "", err
+	}
+	provider := Chain(baseProvider, middlewares...)
+
+	if !isVersionNegotiationCommand(env.Command) {
+		supported, err := providerSupportsInterfaceVersion(ctx, baseProvider, env.InterfaceVersion)
+		if err != nil {
+			return "", err
+		}
+		if !supported {
+			return "", gErrors.NewBadRequestError("provider does not support interface version %s", env.InterfaceVersion)
+		}
+	}
+
 	var ret string
 	switch env.Command {
 	case CreateInstanceCommand:
@@ -151,32 +478,30 @@ func Run(ctx context.Context, provider ExternalProvider, env Environment) (strin
 		if err != nil {
 			return "", fmt.Errorf("failed to create instance in provider: %w", err)
 		}
+		if err := validateInstance(instance); err != nil {
+			return "", err
+		}
 
-		asJs, err := json.Marshal(instance)
+		ret, err = codec.encode(instance)
 		if err != nil {
-			return "", fmt.Errorf("failed to marshal response: %w", err)
+			return "", err
 		}
-		ret = string(asJs)
 	case GetInstanceCommand:
 		instance, err := provider.GetInstance(ctx, env.InstanceID)
 		if err != nil {
 			return "", fmt.Errorf("failed to get instance from provider: %w", err)
 		}
-		asJs, err := json.Marshal(instance)
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal response: %w", err)
+		if err := validateInstance(instance); err != nil {
+			return "", err
 		}
-		ret = string(asJs)
-	case ListInstancesCommand:
-		instances, err := provider.ListInstances(ctx, env.PoolID)
+		ret, err = codec.encode(instance)
 		if err != nil {
-			return "", fmt.Errorf("failed to list instances from provider: %w", err)
+			return "", err
 		}
-		asJs, err := json.Marshal(instances)
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal response: %w", err)
+	case ListInstancesCommand:
+		if err := writeInstances(ctx, codec, provider, env, w); err != nil {
+			return "", err
 		}
-		ret = string(asJs)
 	case DeleteInstanceCommand:
 		if err := provider.DeleteInstance(ctx, env.InstanceID); err != nil {
 			return "", fmt.Errorf("failed to delete instance from provider: %w", err)
@@ -190,11 +515,111 @@ func Run(ctx context.Context, provider ExternalProvider, env Environment) (strin
 			return "", fmt.Errorf("failed to start instance: %w", err)
 		}
 	case StopInstanceCommand:
-		if err := provider.Stop(ctx, env.InstanceID, true); err != nil {
+		var err error
+		switch {
+		case !env.GracefulStop:
+			err = provider.Stop(ctx, env.InstanceID, true)
+		case env.GracefulStopTimeout > 0:
+			err = GracefulStop(ctx, provider, env.InstanceID, env.GracefulStopTimeout, DefaultGracefulStopPollInterval)
+		default:
+			err = provider.Stop(ctx, env.InstanceID, false)
+		}
+		if err != nil {
 			return "", fmt.Errorf("failed to stop instance: %w", err)
 		}
+	case CreateInstancesCommand:
+		results, err := CreateInstances(ctx, batchTarget(baseProvider, provider), env.BatchBootstrapParams, env.BatchConcurrency)
+		if err != nil {
+			return "", fmt.Errorf("failed to create instances in provider: %w", err)
+		}
+		for i, result := range results {
+			if result.Instance == nil {
+				continue
+			}
+			if err := validateInstance(*result.Instance); err != nil {
+				results[i] = NewBatchCreateResult(result.Name, params.ProviderInstance{}, err)
+			}
+		}
+		ret, err = codec.encode(results)
+		if err != nil {
+			return "", err
+		}
+	case DeleteInstancesCommand:
+		results, err := DeleteInstances(ctx, batchTarget(baseProvider, provider), env.BatchInstanceIDs, env.BatchConcurrency)
+		if err != nil {
+			return "", fmt.Errorf("failed to delete instances from provider: %w", err)
+		}
+		ret, err = codec.encode(results)
+		if err != nil {
+			return "", err
+		}
+	case ReconcileCommand:
+		report, err := Reconcile(ctx, provider, env.PoolID, env.ExpectedInstances, ReconcileOptions{
+			DeleteOrphans:  env.DeleteOrphans,
+			Concurrency:    env.BatchConcurrency,
+			AllowDeleteAll: env.AllowDeleteAll,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to reconcile instances: %w", err)
+		}
+		ret, err = codec.encode(report)
+		if err != nil {
+			return "", err
+		}
+	case ValidateExtraSpecsCommand:
+		validator, ok := baseProvider.(ExtraSpecsValidator)
+		if !ok {
+			return "", fmt.Errorf("provider does not implement %s", ValidateExtraSpecsCommand)
+		}
+		if err := validator.ValidateExtraSpecs(ctx, env.ExtraSpecs); err != nil {
+			return "", gErrors.NewBadRequestError("invalid extra specs: %s", err)
+		}
+	case GetVersionCommand:
+		versionProvider, ok := baseProvider.(VersionProvider)
+		if !ok {
+			return "", fmt.Errorf("provider does not implement %s", GetVersionCommand)
+		}
+		version, err := versionProvider.GetVersion(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to get provider version: %w", err)
+		}
+		ret, err = codec.encode(version)
+		if err != nil {
+			return "", err
+		}
+	case GetSupportedInterfaceVersionsCommand:
+		versions, err := getProviderInterfaceVersions(ctx, baseProvider)
+		if err != nil {
+			return "", err
+		}
+		ret, err = codec.encode(versions)
+		if err != nil {
+			return "", err
+		}
+	case GetCapabilitiesCommand:
+		capabilitiesProvider, ok := baseProvider.(CapabilitiesProvider)
+		if !ok {
+			return "", gErrors.NewBadRequestError("provider does not implement %s", GetCapabilitiesCommand)
+		}
+		capabilities, err := capabilitiesProvider.GetCapabilities(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to get provider capabilities: %w", err)
+		}
+		ret, err = codec.encode(capabilities)
+		if err != nil {
+			return "", err
+		}
 	default:
 		return "", fmt.Errorf("invalid command: %s", env.Command)
 	}
 	return ret, nil
 }
+
+// validateInstance makes sure a provider never emits an instance with a malformed status
+// or address.
+func validateInstance(instance params.ProviderInstance) error {
+	if err := instance.Validate(); err != nil {
+		return fmt.Errorf("provider returned an invalid instance %s (%s): %w", instance.Name, instance.ProviderID, err)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package execution
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/extraspecs"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+type versionedTestProvider struct {
+	*testProvider
+	versions   []string
+	versionErr error
+}
+
+func (p *versionedTestProvider) GetVersion(ctx context.Context) (string, error) {
+	if p.versionErr != nil {
+		return "", p.versionErr
+	}
+	return "v1.2.3", nil
+}
+
+func (p *versionedTestProvider) GetSupportedInterfaceVersions(ctx context.Context) ([]string, error) {
+	if p.versionErr != nil {
+		return nil, p.versionErr
+	}
+	return p.versions, nil
+}
+
+func TestGetEnvironmentInterfaceVersion(t *testing.T) {
+	setTestEnvironment(t, ListInstancesCommand)
+	t.Setenv("GARM_INTERFACE_VERSION", "")
+
+	env, err := GetEnvironment()
+	require.NoError(t, err)
+	require.Equal(t, DefaultInterfaceVersion, env.InterfaceVersion)
+	require.Nil(t, env.ExtraSpecs)
+}
+
+func TestGetEnvironmentPoolExtraSpecs(t *testing.T) {
+	setTestEnvironment(t, ListInstancesCommand)
+	t.Setenv("GARM_INTERFACE_VERSION", InterfaceVersion011)
+	t.Setenv("GARM_POOL_EXTRASPECS", base64.StdEncoding.EncodeToString([]byte(`{"key": "value"}`)))
+
+	env, err := GetEnvironment()
+	require.NoError(t, err)
+	require.Equal(t, InterfaceVersion011, env.InterfaceVersion)
+	require.JSONEq(t, `{"key": "value"}`, string(env.ExtraSpecs))
+}
+
+func TestGetEnvironmentUnknownInterfaceVersion(t *testing.T) {
+	setTestEnvironment(t, ListInstancesCommand)
+	t.Setenv("GARM_INTERFACE_VERSION", "v9.9.9")
+
+	_, err := GetEnvironment()
+	require.EqualError(t, err, "unsupported interface version: v9.9.9")
+	require.Equal(t, ExitCodeBadRequest, ResolveErrorToExitCode(err))
+
+	// The version negotiation commands must work with any requested version.
+	for _, command := range []ExecutionCommand{GetVersionCommand, GetSupportedInterfaceVersionsCommand} {
+		setTestEnvironment(t, command)
+		t.Setenv("GARM_INTERFACE_VERSION", "v0.2.0")
+
+		env, err := GetEnvironment()
+		require.NoError(t, err)
+		require.NoError(t, env.Validate())
+		require.Equal(t, "v0.2.0", env.InterfaceVersion)
+
+		_, err = Run(context.Background(), &versionedTestProvider{testProvider: newTestProvider()}, env)
+		require.NoError(t, err)
+	}
+}
+
+func TestRunGetSupportedInterfaceVersions(t *testing.T) {
+	env := Environment{
+		Command: GetSupportedInterfaceVersionsCommand,
+	}
+
+	ret, err := Run(context.Background(), newTestProvider(), env)
+	require.NoError(t, err)
+	require.JSONEq(t, `["v0.1.0", "v0.1.1"]`, ret)
+
+	provider := &versionedTestProvider{
+		testProvider: newTestProvider(),
+		versions:     []string{InterfaceVersion010},
+	}
+	ret, err = Run(context.Background(), provider, env)
+	require.NoError(t, err)
+	require.JSONEq(t, `["v0.1.0"]`, ret)
+
+	provider.versionErr = gErrors.NewUnauthorizedError("bad credentials")
+	_, err = Run(context.Background(), provider, env)
+	require.EqualError(t, err, "failed to get supported interface versions: bad credentials")
+	require.Equal(t, ExitCodeUnauthorized, ResolveErrorToExitCode(err))
+
+	// The supported versions are also checked before running other commands.
+	env.Command = ListInstancesCommand
+	_, err = Run(context.Background(), provider, env)
+	require.EqualError(t, err, "failed to get supported interface versions: bad credentials")
+}
+
+func TestRunGetVersion(t *testing.T) {
+	env := Environment{
+		Command: GetVersionCommand,
+	}
+
+	_, err := Run(context.Background(), newTestProvider(), env)
+	require.EqualError(t, err, "provider does not implement GetVersion")
+
+	provider := &versionedTestProvider{
+		testProvider: newTestProvider(),
+	}
+	ret, err := Run(context.Background(), provider, env)
+	require.NoError(t, err)
+	require.Equal(t, `"v1.2.3"`, ret)
+
+	provider.versionErr = gErrors.NewUnauthorizedError("bad credentials")
+	_, err = Run(context.Background(), provider, env)
+	require.EqualError(t, err, "failed to get provider version: bad credentials")
+	require.Equal(t, ExitCodeUnauthorized, ResolveErrorToExitCode(err))
+}
+
+func TestRunUnsupportedInterfaceVersion(t *testing.T) {
+	provider := &versionedTestProvider{
+		testProvider: newTestProvider(),
+		versions:     []string{InterfaceVersion010},
+	}
+	env := Environment{
+		Command:          ListInstancesCommand,
+		PoolID:           "test-pool",
+		InterfaceVersion: InterfaceVersion011,
+	}
+
+	_, err := Run(context.Background(), provider, env)
+	require.Error(t, err)
+	var badRequest *gErrors.BadRequestError
+	require.ErrorAs(t, err, &badRequest)
+	require.EqualError(t, err, "provider does not support interface version v0.1.1")
+}
+
+type capableTestProvider struct {
+	*testProvider
+}
+
+func (p *capableTestProvider) GetCapabilities(ctx context.Context) (params.ProviderCapabilities, error) {
+	return params.ProviderCapabilities{
+		OSTypes:  []params.OSType{params.Linux},
+		OSArches: []params.OSArch{params.Amd64, params.Arm64},
+		Features: []params.ProviderFeature{params.FeatureSSHKeys, params.FeatureJITConfig},
+	}, nil
+}
+
+func TestRunGetCapabilities(t *testing.T) {
+	env := Environment{
+		Command: GetCapabilitiesCommand,
+	}
+
+	_, err := Run(context.Background(), newTestProvider(), env)
+	require.EqualError(t, err, "provider does not implement GetCapabilities")
+	require.Equal(t, ExitCodeBadRequest, ResolveErrorToExitCode(err))
+
+	provider := &capableTestProvider{
+		testProvider: newTestProvider(),
+	}
+	ret, err := Run(context.Background(), provider, env)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"os_types": ["linux"],
+		"os_arches": ["amd64", "arm64"],
+		"features": ["ssh_keys", "jit_config"],
+		"extra_specs_keys": []
+	}`, ret)
+}
+
+func TestGetEnvironmentStopForce(t *testing.T) {
+	setTestEnvironment(t, StopInstanceCommand)
+
+	env, err := GetEnvironment()
+	require.NoError(t, err)
+	require.False(t, env.GracefulStop)
+
+	t.Setenv("GARM_STOP_FORCE", "false")
+	t.Setenv("GARM_STOP_GRACEFUL_TIMEOUT", "2m")
+	env, err = GetEnvironment()
+	require.NoError(t, err)
+	require.True(t, env.GracefulStop)
+	require.Equal(t, 2*time.Minute, env.GracefulStopTimeout)
+
+	t.Setenv("GARM_STOP_FORCE", "maybe")
+	_, err = GetEnvironment()
+	require.Error(t, err)
+}
+
+func TestRunRejectsInvalidInstance(t *testing.T) {
+	provider := newTestProvider()
+	provider.instances["test-instance"] = params.ProviderInstance{
+		Name:   "test-instance",
+		Status: params.InstanceStatus("booting"),
+		Addresses: []params.Address{
+			{Address: "10.0.0.1", Type: params.PrivateAddress},
+			{Address: "not an address", Type: params.PublicAddress},
+		},
+	}
+	env := Environment{
+		Command:          GetInstanceCommand,
+		InstanceID:       "test-instance",
+		InterfaceVersion: DefaultInterfaceVersion,
+	}
+
+	_, err := Run(context.Background(), provider, env)
+	require.ErrorContains(t, err, "provider returned an invalid instance test-instance")
+	require.ErrorContains(t, err, `unknown status "booting"`)
+
+	env.Command = ListInstancesCommand
+	env.PoolID = "pool"
+	_, err = Run(context.Background(), provider, env)
+	require.ErrorContains(t, err, "provider returned an invalid instance test-instance")
+
+	instance := provider.instances["test-instance"]
+	instance.Status = params.InstanceRunning
+	provider.instances["test-instance"] = instance
+	_, err = Run(context.Background(), provider, env)
+	require.ErrorContains(t, err, `invalid address "not an address"`)
+}
+
+func TestValidateBootstrapParams(t *testing.T) {
+	setTestEnvironment(t, CreateInstanceCommand)
+	env := Environment{
+		Command:            CreateInstanceCommand,
+		ControllerID:       "test-controller",
+		PoolID:             "test-pool",
+		ProviderConfigFile: os.Getenv("GARM_PROVIDER_CONFIG_FILE"),
+		InterfaceVersion:   DefaultInterfaceVersion,
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "test-instance",
+			OSType: params.Linux,
+			OSArch: "sparc",
+		},
+	}
+	require.ErrorContains(t, env.Validate(), `invalid bootstrap params: invalid fields: arch: unsupported architecture "sparc"`)
+}
+
+type extraSpecsTestProvider struct {
+	*testProvider
+	registry *extraspecs.Registry
+}
+
+func (p *extraSpecsTestProvider) ValidateExtraSpecs(ctx context.Context, extraSpecs json.RawMessage) error {
+	return p.registry.Validate(extraSpecs)
+}
+
+func TestRunValidateExtraSpecs(t *testing.T) {
+	env := Environment{
+		Command:    ValidateExtraSpecsCommand,
+		ExtraSpecs: json.RawMessage(`{"flavor": "large"}`),
+	}
+
+	_, err := Run(context.Background(), newTestProvider(), env)
+	require.EqualError(t, err, "provider does not implement ValidateExtraSpecs")
+
+	registry := extraspecs.NewRegistry()
+	require.NoError(t, registry.Register("test", struct {
+		Flavor string `json:"flavor"`
+	}{}))
+	provider := &extraSpecsTestProvider{
+		testProvider: newTestProvider(),
+		registry:     registry,
+	}
+
+	ret, err := Run(context.Background(), provider, env)
+	require.NoError(t, err)
+	require.Empty(t, ret)
+
+	env.ExtraSpecs = json.RawMessage(`{"flavour": "large"}`)
+	_, err = Run(context.Background(), provider, env)
+	var badRequest *gErrors.BadRequestError
+	require.ErrorAs(t, err, &badRequest)
+	require.EqualError(t, err, "invalid extra specs: unknown keys: flavour")
+}
@@ -0,0 +1,345 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package fake implements an in-memory execution.ExternalProvider that simulates a
+// cloud. It is meant to be used in tests.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+var _ execution.ExternalProvider = &Provider{}
+
+// DefaultTransitions are the statuses a newly created instance goes through.
+var DefaultTransitions = []params.InstanceStatus{
+	params.InstancePendingCreate,
+	params.InstanceCreating,
+	params.InstanceRunning,
+}
+
+// Config holds the settings of the fake provider.
+type Config struct {
+	// Latency is added to every call.
+	Latency time.Duration
+	// Transitions are the statuses a newly created instance goes through. The instance
+	// remains in the last status. Defaults to DefaultTransitions.
+	Transitions []params.InstanceStatus
+	// TransitionInterval is the amount of time an instance spends in each status
+	// before moving to the next one. If zero, instances only move to the next status
+	// when Advance() is called.
+	TransitionInterval time.Duration
+}
+
+type injectedError struct {
+	err error
+	// remaining is the number of calls that will still fail. A negative value means
+	// all calls will fail until the error is cleared.
+	remaining int
+}
+
+type instance struct {
+	params.ProviderInstance
+
+	poolID     string
+	transition int
+	changedAt  time.Time
+}
+
+// NewProvider returns a new fake provider.
+func NewProvider(cfg Config) *Provider {
+	if len(cfg.Transitions) == 0 {
+		cfg.Transitions = DefaultTransitions
+	}
+
+	return &Provider{
+		cfg:       cfg,
+		instances: map[string]*instance{},
+		errors:    map[execution.ExecutionCommand]*injectedError{},
+		latency:   map[execution.ExecutionCommand]time.Duration{},
+		calls:     map[execution.ExecutionCommand]int{},
+		now:       time.Now,
+	}
+}
+
+// Provider is an in-memory execution.ExternalProvider.
+type Provider struct {
+	mux sync.Mutex
+
+	cfg       Config
+	instances map[string]*instance
+	errors    map[execution.ExecutionCommand]*injectedError
+	latency   map[execution.ExecutionCommand]time.Duration
+	calls     map[execution.ExecutionCommand]int
+	nextID    int
+	now       func() time.Time
+}
+
+// InjectError makes the next count calls of the method that handles the given command
+// (eg: execution.CreateInstanceCommand for CreateInstance) fail with err. If count is
+// negative, all calls fail until ClearErrors() is called. A count of zero clears the
+// error injected for the command.
+func (p *Provider) InjectError(command execution.ExecutionCommand, err error, count int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if count == 0 {
+		delete(p.errors, command)
+		return
+	}
+	p.errors[command] = &injectedError{
+		err:       err,
+		remaining: count,
+	}
+}
+
+// ClearErrors removes all injected errors.
+func (p *Provider) ClearErrors() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.errors = map[execution.ExecutionCommand]*injectedError{}
+}
+
+// SetLatency sets the latency of the method that handles the given command. It overrides
+// Config.Latency.
+func (p *Provider) SetLatency(command execution.ExecutionCommand, latency time.Duration) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.latency[command] = latency
+}
+
+// Calls returns the number of times the method that handles the given command was called.
+func (p *Provider) Calls(command execution.ExecutionCommand) int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.calls[command]
+}
+
+// Advance moves the instance to its next scripted status.
+func (p *Provider) Advance(instanceID string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	inst, ok := p.instances[instanceID]
+	if !ok {
+		return gErrors.NewNotFoundError("instance %s not found", instanceID)
+	}
+	p.advance(inst)
+	return nil
+}
+
+// SetStatus forcefully sets the status of an instance.
+func (p *Provider) SetStatus(instanceID string, status params.InstanceStatus) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	inst, ok := p.instances[instanceID]
+	if !ok {
+		return gErrors.NewNotFoundError("instance %s not found", instanceID)
+	}
+	inst.Status = status
+	inst.transition = len(p.cfg.Transitions)
+	inst.changedAt = p.now()
+	return nil
+}
+
+// Instances returns a snapshot of all instances, sorted by provider ID.
+func (p *Provider) Instances() []params.ProviderInstance {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	ret := []params.ProviderInstance{}
+	for _, inst := range p.instances {
+		p.refresh(inst)
+		ret = append(ret, inst.ProviderInstance)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ProviderID < ret[j].ProviderID
+	})
+	return ret
+}
+
+func (p *Provider) advance(inst *instance) {
+	if inst.transition >= len(p.cfg.Transitions)-1 {
+		return
+	}
+	inst.transition++
+	inst.Status = p.cfg.Transitions[inst.transition]
+	inst.changedAt = p.now()
+}
+
+// refresh applies the time based transitions of an instance. Must be called with
+// the lock held.
+func (p *Provider) refresh(inst *instance) {
+	if p.cfg.TransitionInterval <= 0 {
+		return
+	}
+
+	for inst.transition < len(p.cfg.Transitions)-1 && p.now().Sub(inst.changedAt) >= p.cfg.TransitionInterval {
+		changedAt := inst.changedAt.Add(p.cfg.TransitionInterval)
+		p.advance(inst)
+		inst.changedAt = changedAt
+	}
+}
+
+// call records the call, waits for the configured latency and returns any injected error.
+func (p *Provider) call(ctx context.Context, command execution.ExecutionCommand) error {
+	p.mux.Lock()
+	p.calls[command]++
+	latency, ok := p.latency[command]
+	if !ok {
+		latency = p.cfg.Latency
+	}
+
+	var err error
+	if injected, ok := p.errors[command]; ok {
+		err = injected.err
+		if injected.remaining > 0 {
+			injected.remaining--
+			if injected.remaining == 0 {
+				delete(p.errors, command)
+			}
+		}
+	}
+	p.mux.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s", gErrors.ErrTimeout, ctx.Err())
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+// CreateInstance creates a new instance in the first scripted status.
+func (p *Provider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	if err := p.call(ctx, execution.CreateInstanceCommand); err != nil {
+		return params.ProviderInstance{}, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.nextID++
+	inst := &instance{
+		ProviderInstance: params.ProviderInstance{
+			ProviderID: fmt.Sprintf("fake-%d", p.nextID),
+			Name:       bootstrapParams.Name,
+			OSType:     bootstrapParams.OSType,
+			OSArch:     bootstrapParams.OSArch,
+			Status:     p.cfg.Transitions[0],
+		},
+		poolID:    bootstrapParams.PoolID,
+		changedAt: p.now(),
+	}
+	p.instances[inst.ProviderID] = inst
+	return inst.ProviderInstance, nil
+}
+
+// DeleteInstance removes an instance. Deleting a missing instance is not an error.
+func (p *Provider) DeleteInstance(ctx context.Context, instance string) error {
+	if err := p.call(ctx, execution.DeleteInstanceCommand); err != nil {
+		return err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	delete(p.instances, instance)
+	return nil
+}
+
+// GetInstance returns an instance, or a NotFoundError if it does not exist.
+func (p *Provider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
+	if err := p.call(ctx, execution.GetInstanceCommand); err != nil {
+		return params.ProviderInstance{}, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	inst, ok := p.instances[instance]
+	if !ok {
+		return params.ProviderInstance{}, gErrors.NewNotFoundError("instance %s not found", instance)
+	}
+	p.refresh(inst)
+	return inst.ProviderInstance, nil
+}
+
+// ListInstances returns the instances in a pool, sorted by provider ID.
+func (p *Provider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
+	if err := p.call(ctx, execution.ListInstancesCommand); err != nil {
+		return nil, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	ret := []params.ProviderInstance{}
+	for _, inst := range p.instances {
+		if inst.poolID != poolID {
+			continue
+		}
+		p.refresh(inst)
+		ret = append(ret, inst.ProviderInstance)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ProviderID < ret[j].ProviderID
+	})
+	return ret, nil
+}
+
+// RemoveAllInstances removes all instances.
+func (p *Provider) RemoveAllInstances(ctx context.Context) error {
+	if err := p.call(ctx, execution.RemoveAllInstancesCommand); err != nil {
+		return err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.instances = map[string]*instance{}
+	return nil
+}
+
+// Stop sets the status of an instance to stopped.
+func (p *Provider) Stop(ctx context.Context, instance string, force bool) error {
+	if err := p.call(ctx, execution.StopInstanceCommand); err != nil {
+		return err
+	}
+	return p.SetStatus(instance, params.InstanceStopped)
+}
+
+// Start sets the status of an instance to running.
+func (p *Provider) Start(ctx context.Context, instance string) error {
+	if err := p.call(ctx, execution.StartInstanceCommand); err != nil {
+		return err
+	}
+	return p.SetStatus(instance, params.InstanceRunning)
+}
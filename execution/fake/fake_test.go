@@ -0,0 +1,95 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/conformance"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	provider := NewProvider(Config{
+		TransitionInterval: time.Millisecond,
+	})
+
+	conformance.Run(t, provider, conformance.Config{
+		Timeout:      10 * time.Second,
+		PollInterval: time.Millisecond,
+	})
+}
+
+func TestTransitions(t *testing.T) {
+	now := time.Now()
+	provider := NewProvider(Config{
+		TransitionInterval: time.Minute,
+	})
+	provider.now = func() time.Time { return now }
+
+	instance, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{Name: "test", PoolID: "pool"})
+	require.NoError(t, err)
+	require.Equal(t, params.InstancePendingCreate, instance.Status)
+
+	now = now.Add(time.Minute)
+	instance, err = provider.GetInstance(context.Background(), instance.ProviderID)
+	require.NoError(t, err)
+	require.Equal(t, params.InstanceCreating, instance.Status)
+
+	now = now.Add(time.Hour)
+	instance, err = provider.GetInstance(context.Background(), instance.ProviderID)
+	require.NoError(t, err)
+	require.Equal(t, params.InstanceRunning, instance.Status)
+}
+
+func TestAdvance(t *testing.T) {
+	provider := NewProvider(Config{})
+
+	instance, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{Name: "test", PoolID: "pool"})
+	require.NoError(t, err)
+
+	for _, status := range []params.InstanceStatus{params.InstanceCreating, params.InstanceRunning, params.InstanceRunning} {
+		require.NoError(t, provider.Advance(instance.ProviderID))
+		instance, err = provider.GetInstance(context.Background(), instance.ProviderID)
+		require.NoError(t, err)
+		require.Equal(t, status, instance.Status)
+	}
+}
+
+func TestInjectError(t *testing.T) {
+	provider := NewProvider(Config{})
+	provider.InjectError(execution.ListInstancesCommand, gErrors.NewProviderError("quota exceeded"), 2)
+
+	for i := 0; i < 2; i++ {
+		_, err := provider.ListInstances(context.Background(), "pool")
+		var providerErr *gErrors.ProviderError
+		require.True(t, errors.As(err, &providerErr))
+	}
+
+	_, err := provider.ListInstances(context.Background(), "pool")
+	require.NoError(t, err)
+	require.Equal(t, 3, provider.Calls(execution.ListInstancesCommand))
+
+	// A count of zero clears the injected error.
+	provider.InjectError(execution.ListInstancesCommand, gErrors.NewProviderError("quota exceeded"), -1)
+	_, err = provider.ListInstances(context.Background(), "pool")
+	require.Error(t, err)
+	provider.InjectError(execution.ListInstancesCommand, gErrors.NewProviderError("quota exceeded"), 0)
+	_, err = provider.ListInstances(context.Background(), "pool")
+	require.NoError(t, err)
+}
+
+func TestLatency(t *testing.T) {
+	provider := NewProvider(Config{})
+	provider.SetLatency(execution.GetInstanceCommand, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := provider.GetInstance(ctx, "missing")
+	require.True(t, errors.Is(err, gErrors.ErrTimeout))
+}
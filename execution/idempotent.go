@@ -0,0 +1,98 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"fmt"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// InstanceFinder is an optional interface that providers can implement to look up an
+// instance by the name it was created with (BootstrapInstance.Name).
+type InstanceFinder interface {
+	// FindInstanceByName returns the instance with the given name in a pool, or a
+	// NotFoundError if there is no such instance.
+	FindInstanceByName(ctx context.Context, poolID, name string) (params.ProviderInstance, error)
+}
+
+// IdempotentCreate returns a middleware that makes CreateInstance idempotent, based on the
+// name of the instance. Before creating an instance, it looks for an existing instance with
+// the same name in the pool. If that instance is healthy (being created or running), it is
+// returned instead of creating a new one. If it is in any other state, an error wrapping
+// ErrDuplicateEntity is returned.
+//
+// Existing instances are looked up using InstanceFinder, if the provider implements it, or
+// by listing the pool otherwise. The middleware only sees the InstanceFinder interface of
+// the provider it wraps, so it should be the last (innermost) middleware in the chain.
+func IdempotentCreate() Middleware {
+	return func(next ExternalProvider) ExternalProvider {
+		return &idempotentProvider{
+			ExternalProvider: next,
+		}
+	}
+}
+
+type idempotentProvider struct {
+	ExternalProvider
+}
+
+// ListInstancesPage forwards paged calls, as embedding hides the PagedLister of next.
+func (i *idempotentProvider) ListInstancesPage(ctx context.Context, poolID string, opts ListInstancesOptions) (ListInstancesPage, error) {
+	return listInstancesPage(ctx, i.ExternalProvider, poolID, opts)
+}
+
+func (i *idempotentProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	existing, err := i.findInstanceByName(ctx, bootstrapParams.PoolID, bootstrapParams.Name)
+	switch {
+	case err == nil:
+		if !isHealthyStatus(existing.Status) {
+			return params.ProviderInstance{}, fmt.Errorf("%w: instance %s already exists with status %s", gErrors.ErrDuplicateEntity, bootstrapParams.Name, existing.Status)
+		}
+		return existing, nil
+	case !isNotFound(err):
+		return params.ProviderInstance{}, fmt.Errorf("failed to look up instance %s: %w", bootstrapParams.Name, err)
+	}
+	return i.ExternalProvider.CreateInstance(ctx, bootstrapParams)
+}
+
+func (i *idempotentProvider) findInstanceByName(ctx context.Context, poolID, name string) (params.ProviderInstance, error) {
+	if finder, ok := i.ExternalProvider.(InstanceFinder); ok {
+		return finder.FindInstanceByName(ctx, poolID, name)
+	}
+
+	instances, err := i.ExternalProvider.ListInstances(ctx, poolID)
+	if err != nil {
+		return params.ProviderInstance{}, err
+	}
+	for _, instance := range instances {
+		if instance.Name == name {
+			return instance, nil
+		}
+	}
+	return params.ProviderInstance{}, gErrors.NewNotFoundError("instance %s not found", name)
+}
+
+// isHealthyStatus returns true if an instance with this status can be handed back to a
+// caller that asked for it to be created.
+func isHealthyStatus(status params.InstanceStatus) bool {
+	switch status {
+	case params.InstancePendingCreate, params.InstanceCreating, params.InstanceRunning:
+		return true
+	}
+	return false
+}
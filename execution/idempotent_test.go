@@ -0,0 +1,73 @@
+package execution_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/fake"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+type findingProvider struct {
+	*fake.Provider
+	lookups int
+}
+
+func (p *findingProvider) FindInstanceByName(ctx context.Context, poolID, name string) (params.ProviderInstance, error) {
+	p.lookups++
+	for _, instance := range p.Instances() {
+		if instance.Name == name {
+			return instance, nil
+		}
+	}
+	return params.ProviderInstance{}, gErrors.NewNotFoundError("instance %s not found", name)
+}
+
+func TestIdempotentCreate(t *testing.T) {
+	base := fake.NewProvider(fake.Config{})
+	provider := execution.Chain(base, execution.IdempotentCreate())
+	bootstrapParams := params.BootstrapInstance{Name: "runner", PoolID: "pool"}
+
+	first, err := provider.CreateInstance(context.Background(), bootstrapParams)
+	require.NoError(t, err)
+
+	second, err := provider.CreateInstance(context.Background(), bootstrapParams)
+	require.NoError(t, err)
+	require.Equal(t, first.ProviderID, second.ProviderID)
+	require.Equal(t, 1, base.Calls(execution.CreateInstanceCommand))
+	require.Len(t, base.Instances(), 1)
+}
+
+func TestIdempotentCreateConflict(t *testing.T) {
+	base := fake.NewProvider(fake.Config{})
+	provider := execution.Chain(base, execution.IdempotentCreate())
+	bootstrapParams := params.BootstrapInstance{Name: "runner", PoolID: "pool"}
+
+	instance, err := provider.CreateInstance(context.Background(), bootstrapParams)
+	require.NoError(t, err)
+	require.NoError(t, base.SetStatus(instance.ProviderID, params.InstanceError))
+
+	_, err = provider.CreateInstance(context.Background(), bootstrapParams)
+	require.True(t, errors.Is(err, gErrors.ErrDuplicateEntity))
+	require.Equal(t, execution.ExitCodeDuplicate, execution.ResolveErrorToExitCode(err))
+	require.Equal(t, 1, base.Calls(execution.CreateInstanceCommand))
+}
+
+func TestIdempotentCreateUsesFinder(t *testing.T) {
+	base := &findingProvider{Provider: fake.NewProvider(fake.Config{})}
+	provider := execution.Chain(base, execution.IdempotentCreate())
+	bootstrapParams := params.BootstrapInstance{Name: "runner", PoolID: "pool"}
+
+	_, err := provider.CreateInstance(context.Background(), bootstrapParams)
+	require.NoError(t, err)
+	_, err = provider.CreateInstance(context.Background(), bootstrapParams)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, base.lookups)
+	require.Equal(t, 0, base.Calls(execution.ListInstancesCommand))
+	require.Equal(t, 1, base.Calls(execution.CreateInstanceCommand))
+}
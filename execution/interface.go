@@ -16,6 +16,7 @@ package execution
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/cloudbase/garm-provider-common/params"
 )
@@ -39,3 +40,18 @@ type ExternalProvider interface {
 	// Start boots up an instance.
 	Start(ctx context.Context, instance string) error
 }
+
+// CapabilitiesProvider is an optional interface that providers can implement to report
+// which operating systems, architectures and features they support.
+type CapabilitiesProvider interface {
+	// GetCapabilities returns the capabilities of the provider.
+	GetCapabilities(ctx context.Context) (params.ProviderCapabilities, error)
+}
+
+// ExtraSpecsValidator is an optional interface that providers can implement to validate
+// the extra specs of a pool before GARM saves them. Providers will usually check the
+// extra specs against an extraspecs.Registry.
+type ExtraSpecsValidator interface {
+	// ValidateExtraSpecs returns an error if the extra specs are not valid.
+	ValidateExtraSpecs(ctx context.Context, extraSpecs json.RawMessage) error
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// OutputFormat is the format in which the ListInstances command writes its result.
+type OutputFormat string
+
+const (
+	// OutputFormatJSON writes the result as a single JSON document. This is the default.
+	OutputFormatJSON OutputFormat = "json"
+	// OutputFormatNDJSON writes one instance per line. If there are more pages, the last
+	// line is a ListInstancesTrailer holding the continuation token.
+	OutputFormatNDJSON OutputFormat = "ndjson"
+)
+
+// ListInstancesOptions holds the options of the ListInstances command.
+type ListInstancesOptions struct {
+	// Status only returns instances with the given status, if set.
+	Status params.InstanceStatus `json:"status,omitempty"`
+	// PageSize is the maximum number of instances returned in one page. If zero,
+	// all instances are returned.
+	PageSize int `json:"page_size,omitempty"`
+	// ContinuationToken is the token returned with the previous page.
+	ContinuationToken string `json:"continuation_token,omitempty"`
+}
+
+// IsPaged returns true if the options ask for a single page of results.
+func (o ListInstancesOptions) IsPaged() bool {
+	return o.PageSize > 0 || o.ContinuationToken != ""
+}
+
+// ListInstancesPage is a page of instances.
+type ListInstancesPage struct {
+	Instances []params.ProviderInstance `json:"instances"`
+	// ContinuationToken is used to fetch the next page. It is empty on the last page.
+	ContinuationToken string `json:"continuation_token,omitempty"`
+}
+
+// ListInstancesTrailer is the last line written in NDJSON mode, when there are more pages.
+type ListInstancesTrailer struct {
+	ContinuationToken string `json:"continuation_token"`
+}
+
+// PagedLister is an optional interface that providers can implement to list instances
+// page by page, instead of loading the whole pool in memory. If the page size is zero,
+// the provider picks one. Providers that don't implement it get paged in memory, based
+// on the result of ListInstances.
+type PagedLister interface {
+	// ListInstancesPage returns one page of instances in a pool.
+	ListInstancesPage(ctx context.Context, poolID string, opts ListInstancesOptions) (ListInstancesPage, error)
+}
+
+// PageInstances applies the list options to a list of instances. If a page size or a
+// continuation token is given, instances are sorted by provider ID and the continuation
+// token is the provider ID of the last instance in the page. Otherwise, the order of the
+// instances is kept.
+func PageInstances(instances []params.ProviderInstance, opts ListInstancesOptions) ListInstancesPage {
+	filtered := []params.ProviderInstance{}
+	for _, instance := range instances {
+		if opts.Status != "" && instance.Status != opts.Status {
+			continue
+		}
+		if opts.ContinuationToken != "" && instance.ProviderID <= opts.ContinuationToken {
+			continue
+		}
+		filtered = append(filtered, instance)
+	}
+	if !opts.IsPaged() {
+		return ListInstancesPage{Instances: filtered}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].ProviderID < filtered[j].ProviderID
+	})
+
+	if opts.PageSize <= 0 || len(filtered) <= opts.PageSize {
+		return ListInstancesPage{Instances: filtered}
+	}
+
+	page := filtered[:opts.PageSize]
+	return ListInstancesPage{
+		Instances:         page,
+		ContinuationToken: page[len(page)-1].ProviderID,
+	}
+}
+
+// listInstancesPage fetches a single page from the provider, using PagedLister if the
+// provider implements it. The middlewares implement PagedLister, so paged calls go
+// through them like any other call.
+func listInstancesPage(ctx context.Context, provider ExternalProvider, poolID string, opts ListInstancesOptions) (ListInstancesPage, error) {
+	if pagedLister, ok := provider.(PagedLister); ok {
+		return pagedLister.ListInstancesPage(ctx, poolID, opts)
+	}
+
+	instances, err := provider.ListInstances(ctx, poolID)
+	if err != nil {
+		return ListInstancesPage{}, err
+	}
+	return PageInstances(instances, opts), nil
+}
+
+// writeInstances runs the ListInstances command and writes the result to w. If the
+// options don't ask for a single page, all pages are fetched. In NDJSON mode, each page
+// is written as soon as it is received.
+func writeInstances(ctx context.Context, codec interfaceCodec, provider ExternalProvider, env Environment, w io.Writer) error {
+	opts := env.ListOptions
+	paged := opts.IsPaged()
+
+	var all []params.ProviderInstance
+	seenTokens := map[string]bool{}
+	for {
+		page, err := listInstancesPage(ctx, provider, env.PoolID, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list instances from provider: %w", err)
+		}
+		for _, instance := range page.Instances {
+			if err := validateInstance(instance); err != nil {
+				return err
+			}
+		}
+
+		if env.OutputFormat == OutputFormatNDJSON {
+			if err := writeNDJSONPage(codec, page, paged, w); err != nil {
+				return err
+			}
+		} else {
+			all = append(all, page.Instances...)
+		}
+
+		if paged {
+			if env.OutputFormat == OutputFormatNDJSON {
+				return nil
+			}
+			page.Instances = all
+			return writeEncoded(codec, page, w)
+		}
+
+		if page.ContinuationToken == "" {
+			break
+		}
+		if seenTokens[page.ContinuationToken] {
+			return fmt.Errorf("provider returned continuation token %q more than once", page.ContinuationToken)
+		}
+		seenTokens[page.ContinuationToken] = true
+		opts.ContinuationToken = page.ContinuationToken
+	}
+
+	if env.OutputFormat == OutputFormatNDJSON {
+		return nil
+	}
+	if all == nil {
+		all = []params.ProviderInstance{}
+	}
+	return writeEncoded(codec, all, w)
+}
+
+func writeNDJSONPage(codec interfaceCodec, page ListInstancesPage, withTrailer bool, w io.Writer) error {
+	for _, instance := range page.Instances {
+		if err := writeEncoded(codec, instance, w); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return fmt.Errorf("failed to write instance: %w", err)
+		}
+	}
+
+	if !withTrailer || page.ContinuationToken == "" {
+		return nil
+	}
+	if err := writeEncoded(codec, ListInstancesTrailer{ContinuationToken: page.ContinuationToken}, w); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("failed to write continuation token: %w", err)
+	}
+	return nil
+}
+
+func writeEncoded(codec interfaceCodec, v interface{}, w io.Writer) error {
+	data, err := codec.encode(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, data); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+	return nil
+}
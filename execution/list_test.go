@@ -0,0 +1,159 @@
+package execution
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+type pagedTestProvider struct {
+	*testProvider
+	pages map[string]ListInstancesPage
+}
+
+func (p *pagedTestProvider) ListInstancesPage(ctx context.Context, poolID string, opts ListInstancesOptions) (ListInstancesPage, error) {
+	page, ok := p.pages[opts.ContinuationToken]
+	if !ok {
+		return ListInstancesPage{}, fmt.Errorf("unexpected continuation token: %s", opts.ContinuationToken)
+	}
+	return page, nil
+}
+
+func testInstances() []params.ProviderInstance {
+	return []params.ProviderInstance{
+		{ProviderID: "c", Status: params.InstanceRunning},
+		{ProviderID: "a", Status: params.InstanceRunning},
+		{ProviderID: "b", Status: params.InstanceStopped},
+		{ProviderID: "d", Status: params.InstanceRunning},
+	}
+}
+
+func TestPageInstances(t *testing.T) {
+	page := PageInstances(testInstances(), ListInstancesOptions{PageSize: 2})
+	require.Equal(t, []params.ProviderInstance{
+		{ProviderID: "a", Status: params.InstanceRunning},
+		{ProviderID: "b", Status: params.InstanceStopped},
+	}, page.Instances)
+	require.Equal(t, "b", page.ContinuationToken)
+
+	page = PageInstances(testInstances(), ListInstancesOptions{PageSize: 2, ContinuationToken: page.ContinuationToken})
+	require.Len(t, page.Instances, 2)
+	require.Equal(t, "c", page.Instances[0].ProviderID)
+	require.Empty(t, page.ContinuationToken)
+
+	// Without a page size or token, the order of the instances is kept.
+	page = PageInstances(testInstances(), ListInstancesOptions{Status: params.InstanceRunning})
+	require.Equal(t, []string{"c", "a", "d"}, []string{page.Instances[0].ProviderID, page.Instances[1].ProviderID, page.Instances[2].ProviderID})
+	require.Empty(t, page.ContinuationToken)
+}
+
+func TestRunListInstancesNDJSON(t *testing.T) {
+	provider := newTestProvider()
+	for _, instance := range testInstances() {
+		provider.instances[instance.ProviderID] = instance
+	}
+	env := Environment{
+		Command:          ListInstancesCommand,
+		PoolID:           "pool",
+		InterfaceVersion: DefaultInterfaceVersion,
+		ListOptions:      ListInstancesOptions{Status: params.InstanceRunning, PageSize: 2},
+		OutputFormat:     OutputFormatNDJSON,
+	}
+
+	out, err := Run(context.Background(), provider, env)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	require.JSONEq(t, `{"provider_id":"a","status":"running"}`, lines[0])
+	require.JSONEq(t, `{"provider_id":"c","status":"running"}`, lines[1])
+	require.JSONEq(t, `{"continuation_token":"c"}`, lines[2])
+}
+
+func TestRunListInstancesPagedJSON(t *testing.T) {
+	provider := newTestProvider()
+	for _, instance := range testInstances() {
+		provider.instances[instance.ProviderID] = instance
+	}
+	env := Environment{
+		Command:          ListInstancesCommand,
+		PoolID:           "pool",
+		InterfaceVersion: DefaultInterfaceVersion,
+		ListOptions:      ListInstancesOptions{PageSize: 3},
+	}
+
+	out, err := Run(context.Background(), provider, env)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"instances":[{"provider_id":"a","status":"running"},{"provider_id":"b","status":"stopped"},{"provider_id":"c","status":"running"}],"continuation_token":"c"}`, out)
+}
+
+func TestRunListInstancesPagedLister(t *testing.T) {
+	provider := &pagedTestProvider{
+		testProvider: newTestProvider(),
+		pages: map[string]ListInstancesPage{
+			"":     {Instances: []params.ProviderInstance{{ProviderID: "a"}}, ContinuationToken: "next"},
+			"next": {Instances: []params.ProviderInstance{{ProviderID: "b"}}},
+		},
+	}
+	env := Environment{
+		Command:          ListInstancesCommand,
+		PoolID:           "pool",
+		InterfaceVersion: DefaultInterfaceVersion,
+	}
+
+	out, err := Run(context.Background(), provider, env)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"provider_id":"a"},{"provider_id":"b"}]`, out)
+
+	env.OutputFormat = OutputFormatNDJSON
+	out, err = Run(context.Background(), provider, env)
+	require.NoError(t, err)
+	require.Equal(t, "{\"provider_id\":\"a\"}\n{\"provider_id\":\"b\"}\n", out)
+}
+
+func TestRunListInstancesRepeatedToken(t *testing.T) {
+	provider := &pagedTestProvider{
+		testProvider: newTestProvider(),
+		pages: map[string]ListInstancesPage{
+			"":     {Instances: []params.ProviderInstance{{ProviderID: "a"}}, ContinuationToken: "next"},
+			"next": {Instances: []params.ProviderInstance{{ProviderID: "b"}}, ContinuationToken: "next"},
+		},
+	}
+	env := Environment{
+		Command:          ListInstancesCommand,
+		PoolID:           "pool",
+		InterfaceVersion: DefaultInterfaceVersion,
+	}
+
+	_, err := Run(context.Background(), provider, env)
+	require.EqualError(t, err, `provider returned continuation token "next" more than once`)
+}
+
+func TestRunListInstancesPagedListerUsesMiddlewares(t *testing.T) {
+	provider := &pagedTestProvider{
+		testProvider: newTestProvider(),
+		pages: map[string]ListInstancesPage{
+			"": {Instances: []params.ProviderInstance{{ProviderID: "a"}}},
+		},
+	}
+	env := Environment{
+		Command:          ListInstancesCommand,
+		PoolID:           "pool",
+		InterfaceVersion: DefaultInterfaceVersion,
+		ListOptions:      ListInstancesOptions{PageSize: 1},
+	}
+
+	var commands []ExecutionCommand
+	track := NewInterceptor(func(ctx context.Context, command ExecutionCommand, call func(ctx context.Context) error) error {
+		commands = append(commands, command)
+		return call(ctx)
+	})
+
+	out, err := Run(context.Background(), provider, env, track, IdempotentCreate())
+	require.NoError(t, err)
+	require.JSONEq(t, `{"instances":[{"provider_id":"a"}]}`, out)
+	require.Equal(t, []ExecutionCommand{ListInstancesCommand}, commands)
+}
@@ -0,0 +1,171 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// CommandTimeoutEnvVar is the name of the environment variable that can be used
+	// to override the default deadline of a command. The value must be parsable by
+	// time.ParseDuration (eg: 30s, 5m).
+	CommandTimeoutEnvVar = "GARM_COMMAND_TIMEOUT"
+)
+
+// DefaultCommandTimeouts holds the default deadline for each command. Commands that
+// are not present in this map will default to DefaultCommandTimeout.
+var DefaultCommandTimeouts = map[ExecutionCommand]time.Duration{
+	CreateInstanceCommand:     10 * time.Minute,
+	DeleteInstanceCommand:     5 * time.Minute,
+	GetInstanceCommand:        1 * time.Minute,
+	ListInstancesCommand:      2 * time.Minute,
+	StartInstanceCommand:      5 * time.Minute,
+	StopInstanceCommand:       5 * time.Minute,
+	RemoveAllInstancesCommand: 30 * time.Minute,
+
+	GetVersionCommand:                    1 * time.Minute,
+	GetSupportedInterfaceVersionsCommand: 1 * time.Minute,
+	GetCapabilitiesCommand:               1 * time.Minute,
+
+	CreateInstancesCommand: 30 * time.Minute,
+	DeleteInstancesCommand: 30 * time.Minute,
+	ReconcileCommand:       30 * time.Minute,
+
+	ValidateExtraSpecsCommand: 1 * time.Minute,
+}
+
+// DefaultCommandTimeout is the deadline used for commands that don't have an entry
+// in DefaultCommandTimeouts.
+const DefaultCommandTimeout = 5 * time.Minute
+
+// ProviderFactory instantiates an external provider for the given environment.
+type ProviderFactory func(ctx context.Context, env Environment) (ExternalProvider, error)
+
+// GetCommandTimeout returns the deadline that should be applied to the given command.
+// The value of GARM_COMMAND_TIMEOUT takes precedence over the defaults.
+func GetCommandTimeout(command ExecutionCommand) (time.Duration, error) {
+	if val := os.Getenv(CommandTimeoutEnvVar); val != "" {
+		timeout, err := time.ParseDuration(val)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s: %w", CommandTimeoutEnvVar, err)
+		}
+		if timeout <= 0 {
+			return 0, fmt.Errorf("invalid %s: %s", CommandTimeoutEnvVar, val)
+		}
+		return timeout, nil
+	}
+
+	if timeout, ok := DefaultCommandTimeouts[command]; ok {
+		return timeout, nil
+	}
+	return DefaultCommandTimeout, nil
+}
+
+// Main is a helper that can be used as the entrypoint of an external provider. It reads the
+// execution environment, instantiates the provider using the supplied factory, runs the
+// requested command and exits with the appropriate exit code. Errors are written to stderr
+// as an ErrorDocument. The context passed to the provider is canceled when the process
+// receives SIGINT or SIGTERM, or when the command deadline expires. The middlewares, if
+// any, are applied to the provider.
+//
+// If GARM_PROVIDER_SOCKET is set, Main will instead instantiate the provider once and
+// serve commands on that unix socket until the process is signaled. See Server.
+//
+// If the process is started with arguments, the command and its parameters are taken
+// from the command line instead of the environment. See ParseArgs.
+func Main(factory ProviderFactory, middlewares ...Middleware) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	var code int
+	switch {
+	case len(os.Args) > 1:
+		// GARM never passes arguments to providers, so this is an operator
+		// running the provider by hand.
+		code = runCLI(ctx, factory, os.Args, os.Stdin, os.Stdout, os.Stderr, middlewares...)
+	case os.Getenv(ServerSocketEnvVar) != "":
+		code = serve(ctx, factory, os.Getenv(ServerSocketEnvVar), os.Stderr, middlewares...)
+	default:
+		code = run(ctx, factory, os.Stdout, os.Stderr, middlewares...)
+	}
+	stop()
+	os.Exit(code)
+}
+
+func serve(ctx context.Context, factory ProviderFactory, socketPath string, stderr io.Writer, middlewares ...Middleware) int {
+	env := Environment{
+		ControllerID:       os.Getenv("GARM_CONTROLLER_ID"),
+		ProviderConfigFile: os.Getenv("GARM_PROVIDER_CONFIG_FILE"),
+	}
+
+	if env.ControllerID == "" {
+		return writeError(stderr, fmt.Errorf("missing GARM_CONTROLLER_ID"))
+	}
+
+	if env.ProviderConfigFile == "" {
+		return writeError(stderr, fmt.Errorf("missing GARM_PROVIDER_CONFIG_FILE"))
+	}
+
+	provider, err := factory(ctx, env)
+	if err != nil {
+		return writeError(stderr, fmt.Errorf("failed to create provider: %w", err))
+	}
+
+	srv := NewServer(provider, env.ControllerID, env.ProviderConfigFile, middlewares...)
+	if err := srv.ServeUnix(ctx, socketPath); err != nil {
+		return writeError(stderr, err)
+	}
+	return 0
+}
+
+func run(ctx context.Context, factory ProviderFactory, stdout, stderr io.Writer, middlewares ...Middleware) int {
+	env, err := GetEnvironment()
+	if err != nil {
+		return writeError(stderr, fmt.Errorf("failed to get environment: %w", err))
+	}
+
+	timeout, err := GetCommandTimeout(env.Command)
+	if err != nil {
+		return writeError(stderr, fmt.Errorf("failed to get command timeout: %w", err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	provider, err := factory(ctx, env)
+	if err != nil {
+		return writeError(stderr, fmt.Errorf("failed to create provider: %w", err))
+	}
+
+	if err := RunTo(ctx, provider, env, stdout, middlewares...); err != nil {
+		return writeError(stderr, err)
+	}
+	return 0
+}
+
+// writeError writes the error document to stderr and returns the exit code that
+// corresponds to the error.
+func writeError(stderr io.Writer, err error) int {
+	if writeErr := WriteErrorDocument(stderr, err); writeErr != nil {
+		fmt.Fprintf(stderr, "%q\n", err)
+	}
+	return ResolveErrorToExitCode(err)
+}
@@ -0,0 +1,174 @@
+package execution
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+type testProvider struct {
+	instances map[string]params.ProviderInstance
+	err       error
+}
+
+func (p *testProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	if p.err != nil {
+		return params.ProviderInstance{}, p.err
+	}
+	instance := params.ProviderInstance{
+		ProviderID: bootstrapParams.Name,
+		Name:       bootstrapParams.Name,
+		OSType:     bootstrapParams.OSType,
+		OSArch:     bootstrapParams.OSArch,
+		Status:     params.InstanceRunning,
+	}
+	p.instances[instance.Name] = instance
+	return instance, nil
+}
+
+func (p *testProvider) DeleteInstance(ctx context.Context, instance string) error {
+	if p.err != nil {
+		return p.err
+	}
+	delete(p.instances, instance)
+	return nil
+}
+
+func (p *testProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
+	if p.err != nil {
+		return params.ProviderInstance{}, p.err
+	}
+	inst, ok := p.instances[instance]
+	if !ok {
+		return params.ProviderInstance{}, gErrors.ErrNotFound
+	}
+	return inst, nil
+}
+
+func (p *testProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	ret := []params.ProviderInstance{}
+	for _, inst := range p.instances {
+		ret = append(ret, inst)
+	}
+	return ret, nil
+}
+
+func (p *testProvider) RemoveAllInstances(ctx context.Context) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.instances = map[string]params.ProviderInstance{}
+	return nil
+}
+
+func (p *testProvider) Stop(ctx context.Context, instance string, force bool) error {
+	return p.err
+}
+
+func (p *testProvider) Start(ctx context.Context, instance string) error {
+	return p.err
+}
+
+func newTestProvider() *testProvider {
+	return &testProvider{
+		instances: map[string]params.ProviderInstance{},
+	}
+}
+
+func setTestEnvironment(t *testing.T, command ExecutionCommand) {
+	cfgFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(cfgFile, []byte{}, 0o600))
+
+	t.Setenv("GARM_COMMAND", string(command))
+	t.Setenv("GARM_CONTROLLER_ID", "test-controller")
+	t.Setenv("GARM_POOL_ID", "test-pool")
+	t.Setenv("GARM_PROVIDER_CONFIG_FILE", cfgFile)
+	t.Setenv("GARM_INSTANCE_ID", "test-instance")
+}
+
+func TestGetCommandTimeoutDefaults(t *testing.T) {
+	t.Setenv(CommandTimeoutEnvVar, "")
+
+	timeout, err := GetCommandTimeout(CreateInstanceCommand)
+	require.NoError(t, err)
+	require.Equal(t, DefaultCommandTimeouts[CreateInstanceCommand], timeout)
+
+	timeout, err = GetCommandTimeout(ExecutionCommand("bogus"))
+	require.NoError(t, err)
+	require.Equal(t, DefaultCommandTimeout, timeout)
+}
+
+func TestGetCommandTimeoutFromEnv(t *testing.T) {
+	t.Setenv(CommandTimeoutEnvVar, "42s")
+
+	timeout, err := GetCommandTimeout(CreateInstanceCommand)
+	require.NoError(t, err)
+	require.Equal(t, 42*time.Second, timeout)
+}
+
+func TestGetCommandTimeoutInvalid(t *testing.T) {
+	t.Setenv(CommandTimeoutEnvVar, "bogus")
+
+	_, err := GetCommandTimeout(CreateInstanceCommand)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to parse GARM_COMMAND_TIMEOUT")
+
+	t.Setenv(CommandTimeoutEnvVar, "-1s")
+	_, err = GetCommandTimeout(CreateInstanceCommand)
+	require.EqualError(t, err, "invalid GARM_COMMAND_TIMEOUT: -1s")
+}
+
+func TestRunMain(t *testing.T) {
+	setTestEnvironment(t, GetInstanceCommand)
+	provider := newTestProvider()
+	provider.instances["test-instance"] = params.ProviderInstance{
+		Name:   "test-instance",
+		Status: params.InstanceRunning,
+	}
+	factory := func(ctx context.Context, env Environment) (ExternalProvider, error) {
+		_, hasDeadline := ctx.Deadline()
+		require.True(t, hasDeadline)
+		return provider, nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), factory, &stdout, &stderr)
+	require.Equal(t, 0, code)
+	require.Empty(t, stderr.String())
+	require.JSONEq(t, `{"name":"test-instance","status":"running"}`, stdout.String())
+}
+
+func TestRunMainNotFound(t *testing.T) {
+	setTestEnvironment(t, GetInstanceCommand)
+	factory := func(ctx context.Context, env Environment) (ExternalProvider, error) {
+		return newTestProvider(), nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), factory, &stdout, &stderr)
+	require.Equal(t, ExitCodeNotFound, code)
+	require.Empty(t, stdout.String())
+	require.Contains(t, stderr.String(), "not found")
+}
+
+func TestRunMainInvalidEnvironment(t *testing.T) {
+	setTestEnvironment(t, ExecutionCommand("bogus"))
+	factory := func(ctx context.Context, env Environment) (ExternalProvider, error) {
+		return newTestProvider(), nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), factory, &stdout, &stderr)
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr.String(), "unknown GARM_COMMAND: bogus")
+}
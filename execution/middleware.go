@@ -0,0 +1,232 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// Middleware decorates an ExternalProvider. Middlewares only wrap the methods of the
+// ExternalProvider and PagedLister interfaces. Other optional interfaces (like
+// CapabilitiesProvider) are looked up by Run() on the undecorated provider.
+type Middleware func(ExternalProvider) ExternalProvider
+
+// Chain applies the middlewares to the provider. The first middleware is the outermost
+// one, which means it sees a call first and its result last.
+func Chain(provider ExternalProvider, middlewares ...Middleware) ExternalProvider {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		provider = middlewares[i](provider)
+	}
+	return provider
+}
+
+// InterceptorFunc is called for every ExternalProvider method call. The command identifies
+// the method being called and call invokes the next provider in the chain.
+type InterceptorFunc func(ctx context.Context, command ExecutionCommand, call func(ctx context.Context) error) error
+
+// NewInterceptor returns a middleware that runs the interceptor function around every
+// ExternalProvider method call.
+func NewInterceptor(intercept InterceptorFunc) Middleware {
+	return func(next ExternalProvider) ExternalProvider {
+		return &interceptedProvider{
+			next:      next,
+			intercept: intercept,
+		}
+	}
+}
+
+type interceptedProvider struct {
+	next      ExternalProvider
+	intercept InterceptorFunc
+}
+
+func (i *interceptedProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	var ret params.ProviderInstance
+	err := i.intercept(ctx, CreateInstanceCommand, func(ctx context.Context) (err error) {
+		ret, err = i.next.CreateInstance(ctx, bootstrapParams)
+		return err
+	})
+	return ret, err
+}
+
+func (i *interceptedProvider) DeleteInstance(ctx context.Context, instance string) error {
+	return i.intercept(ctx, DeleteInstanceCommand, func(ctx context.Context) error {
+		return i.next.DeleteInstance(ctx, instance)
+	})
+}
+
+func (i *interceptedProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
+	var ret params.ProviderInstance
+	err := i.intercept(ctx, GetInstanceCommand, func(ctx context.Context) (err error) {
+		ret, err = i.next.GetInstance(ctx, instance)
+		return err
+	})
+	return ret, err
+}
+
+func (i *interceptedProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
+	var ret []params.ProviderInstance
+	err := i.intercept(ctx, ListInstancesCommand, func(ctx context.Context) (err error) {
+		ret, err = i.next.ListInstances(ctx, poolID)
+		return err
+	})
+	return ret, err
+}
+
+// ListInstancesPage intercepts paged calls as ListInstancesCommand. If the next provider
+// does not implement PagedLister, the page is built from ListInstances.
+func (i *interceptedProvider) ListInstancesPage(ctx context.Context, poolID string, opts ListInstancesOptions) (ListInstancesPage, error) {
+	var ret ListInstancesPage
+	err := i.intercept(ctx, ListInstancesCommand, func(ctx context.Context) (err error) {
+		ret, err = listInstancesPage(ctx, i.next, poolID, opts)
+		return err
+	})
+	return ret, err
+}
+
+func (i *interceptedProvider) RemoveAllInstances(ctx context.Context) error {
+	return i.intercept(ctx, RemoveAllInstancesCommand, func(ctx context.Context) error {
+		return i.next.RemoveAllInstances(ctx)
+	})
+}
+
+func (i *interceptedProvider) Stop(ctx context.Context, instance string, force bool) error {
+	return i.intercept(ctx, StopInstanceCommand, func(ctx context.Context) error {
+		return i.next.Stop(ctx, instance, force)
+	})
+}
+
+func (i *interceptedProvider) Start(ctx context.Context, instance string) error {
+	return i.intercept(ctx, StartInstanceCommand, func(ctx context.Context) error {
+		return i.next.Start(ctx, instance)
+	})
+}
+
+// WithLogging returns a middleware that logs every call, its duration and its error.
+func WithLogging(logger *log.Logger) Middleware {
+	return NewInterceptor(func(ctx context.Context, command ExecutionCommand, call func(ctx context.Context) error) error {
+		start := time.Now()
+		err := call(ctx)
+		if err != nil {
+			logger.Printf("%s failed after %s: %s", command, time.Since(start), err)
+			return err
+		}
+		logger.Printf("%s succeeded after %s", command, time.Since(start))
+		return nil
+	})
+}
+
+// RetryConfig configures the retry middleware.
+type RetryConfig struct {
+	// Attempts is the maximum number of times a call is attempted, including the first one.
+	Attempts int
+	// Backoff is the delay before the first retry. It doubles after every attempt.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between attempts. Zero means no limit.
+	MaxBackoff time.Duration
+}
+
+// WithRetry returns a middleware that retries calls that fail with a retryable error
+// (see IsRetryable). Retrying CreateInstance may result in duplicate instances if the
+// provider is not idempotent, so it should be combined with IdempotentCreate().
+func WithRetry(cfg RetryConfig) Middleware {
+	return NewInterceptor(func(ctx context.Context, command ExecutionCommand, call func(ctx context.Context) error) error {
+		return retry(ctx, cfg, func() error {
+			return call(ctx)
+		})
+	})
+}
+
+// retry calls fn until it succeeds, fails with an error that is not retryable, or
+// the attempts are exhausted.
+func retry(ctx context.Context, cfg RetryConfig, fn func() error) error {
+	backoff := cfg.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !IsRetryable(err) || attempt >= cfg.Attempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if cfg.MaxBackoff > 0 && backoff > cfg.MaxBackoff {
+			backoff = cfg.MaxBackoff
+		}
+	}
+}
+
+// WithTimeouts returns a middleware that applies a deadline to each call, based on the
+// method being called. Methods that are not in the map are not affected.
+func WithTimeouts(timeouts map[ExecutionCommand]time.Duration) Middleware {
+	return NewInterceptor(func(ctx context.Context, command ExecutionCommand, call func(ctx context.Context) error) error {
+		timeout, ok := timeouts[command]
+		if !ok || timeout <= 0 {
+			return call(ctx)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return call(ctx)
+	})
+}
+
+// WithConcurrencyLimit returns a middleware that allows at most limit concurrent calls
+// to the provider. Calls wait for a free slot until their context is canceled. A limit
+// that is not positive means no limit, and the provider is returned undecorated.
+func WithConcurrencyLimit(limit int) Middleware {
+	if limit <= 0 {
+		return func(provider ExternalProvider) ExternalProvider {
+			return provider
+		}
+	}
+
+	slots := make(chan struct{}, limit)
+	return NewInterceptor(func(ctx context.Context, command ExecutionCommand, call func(ctx context.Context) error) error {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to acquire slot for %s: %w", command, ctx.Err())
+		}
+		defer func() { <-slots }()
+
+		return call(ctx)
+	})
+}
+
+// WithPanicRecovery returns a middleware that turns panics in the provider into errors.
+func WithPanicRecovery() Middleware {
+	return NewInterceptor(func(ctx context.Context, command ExecutionCommand, call func(ctx context.Context) error) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("provider panicked in %s: %v\n%s", command, r, debug.Stack())
+			}
+		}()
+		return call(ctx)
+	})
+}
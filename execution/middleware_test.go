@@ -0,0 +1,163 @@
+package execution_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/fake"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	record := func(name string) execution.Middleware {
+		return execution.NewInterceptor(func(ctx context.Context, command execution.ExecutionCommand, call func(ctx context.Context) error) error {
+			order = append(order, name+"-before")
+			err := call(ctx)
+			order = append(order, name+"-after")
+			return err
+		})
+	}
+
+	provider := execution.Chain(fake.NewProvider(fake.Config{}), record("first"), record("second"))
+	_, err := provider.ListInstances(context.Background(), "pool")
+	require.NoError(t, err)
+	require.Equal(t, []string{"first-before", "second-before", "second-after", "first-after"}, order)
+}
+
+func TestWithRetry(t *testing.T) {
+	base := fake.NewProvider(fake.Config{})
+	base.InjectError(execution.ListInstancesCommand, gErrors.NewProviderError("transient"), 2)
+
+	provider := execution.Chain(base, execution.WithRetry(execution.RetryConfig{
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+	}))
+	_, err := provider.ListInstances(context.Background(), "pool")
+	require.NoError(t, err)
+	require.Equal(t, 3, base.Calls(execution.ListInstancesCommand))
+}
+
+func TestWithRetryNotRetryable(t *testing.T) {
+	base := fake.NewProvider(fake.Config{})
+	base.InjectError(execution.DeleteInstanceCommand, gErrors.NewUnauthorizedError("bad credentials"), -1)
+
+	provider := execution.Chain(base, execution.WithRetry(execution.RetryConfig{
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+	}))
+	err := provider.DeleteInstance(context.Background(), "instance")
+	var unauthorizedErr *gErrors.UnauthorizedError
+	require.True(t, errors.As(err, &unauthorizedErr))
+	require.Equal(t, 1, base.Calls(execution.DeleteInstanceCommand))
+}
+
+func TestWithTimeouts(t *testing.T) {
+	base := fake.NewProvider(fake.Config{})
+	base.SetLatency(execution.GetInstanceCommand, time.Hour)
+
+	provider := execution.Chain(base, execution.WithTimeouts(map[execution.ExecutionCommand]time.Duration{
+		execution.GetInstanceCommand: 10 * time.Millisecond,
+	}))
+	_, err := provider.GetInstance(context.Background(), "instance")
+	require.True(t, errors.Is(err, gErrors.ErrTimeout))
+}
+
+func TestWithConcurrencyLimit(t *testing.T) {
+	var current, highest int32
+	track := execution.NewInterceptor(func(ctx context.Context, command execution.ExecutionCommand, call func(ctx context.Context) error) error {
+		val := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+		for {
+			old := atomic.LoadInt32(&highest)
+			if val <= old || atomic.CompareAndSwapInt32(&highest, old, val) {
+				break
+			}
+		}
+		return call(ctx)
+	})
+
+	base := fake.NewProvider(fake.Config{Latency: 5 * time.Millisecond})
+	provider := execution.Chain(base, execution.WithConcurrencyLimit(2), track)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := provider.ListInstances(context.Background(), "pool")
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.LessOrEqual(t, atomic.LoadInt32(&highest), int32(2))
+}
+
+func TestWithConcurrencyLimitUnlimited(t *testing.T) {
+	base := fake.NewProvider(fake.Config{})
+	for _, limit := range []int{0, -1} {
+		provider := execution.Chain(base, execution.WithConcurrencyLimit(limit))
+		require.Same(t, base, provider)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := provider.ListInstances(ctx, "pool")
+		cancel()
+		require.NoError(t, err)
+	}
+}
+
+type panickingProvider struct {
+	*fake.Provider
+}
+
+func (p *panickingProvider) Start(ctx context.Context, instance string) error {
+	panic("boom")
+}
+
+func TestWithPanicRecovery(t *testing.T) {
+	provider := execution.Chain(&panickingProvider{fake.NewProvider(fake.Config{})}, execution.WithPanicRecovery())
+
+	err := provider.Start(context.Background(), "instance")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "provider panicked in StartInstance: boom")
+}
+
+func TestWithLogging(t *testing.T) {
+	var buf bytes.Buffer
+	provider := execution.Chain(fake.NewProvider(fake.Config{}), execution.WithLogging(log.New(&buf, "", 0)))
+
+	_, err := provider.GetInstance(context.Background(), "missing")
+	require.Error(t, err)
+	require.Contains(t, buf.String(), "GetInstance failed after")
+	require.Contains(t, buf.String(), "instance missing not found")
+}
+
+func TestRunWithMiddlewares(t *testing.T) {
+	base := fake.NewProvider(fake.Config{})
+	base.InjectError(execution.CreateInstanceCommand, gErrors.ErrTimeout, 1)
+
+	env := execution.Environment{
+		Command: execution.CreateInstanceCommand,
+		PoolID:  "pool",
+		BootstrapParams: params.BootstrapInstance{
+			Name:   "test",
+			PoolID: "pool",
+		},
+	}
+	ret, err := execution.Run(context.Background(), base, env, execution.WithRetry(execution.RetryConfig{
+		Attempts: 2,
+		Backoff:  time.Millisecond,
+	}))
+	require.NoError(t, err)
+	require.Contains(t, ret, `"name":"test"`)
+	require.Equal(t, 2, base.Calls(execution.CreateInstanceCommand))
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// ExpectedInstance is an instance GARM expects to exist in a pool.
+type ExpectedInstance struct {
+	// Name is the name of the instance.
+	Name string `json:"name"`
+	// ProviderID is the ID of the instance in the provider. If set, the instance is
+	// matched by ID instead of name.
+	ProviderID string `json:"provider_id,omitempty"`
+	// Status is the status GARM expects the instance to be in. If empty, the status
+	// is not checked.
+	Status params.InstanceStatus `json:"status,omitempty"`
+}
+
+// StatusMismatch describes an instance whose status differs from the expected one.
+type StatusMismatch struct {
+	Name       string                `json:"name"`
+	ProviderID string                `json:"provider_id"`
+	Expected   params.InstanceStatus `json:"expected"`
+	Actual     params.InstanceStatus `json:"actual"`
+}
+
+// ReconcileOptions configures Reconcile.
+type ReconcileOptions struct {
+	// DeleteOrphans deletes the instances that exist in the provider, but are not
+	// expected by GARM.
+	DeleteOrphans bool
+	// Concurrency is the number of orphans deleted in parallel. Defaults to
+	// DefaultBatchConcurrency.
+	Concurrency int
+	// AllowDeleteAll allows DeleteOrphans when no instances are expected. Without it,
+	// Reconcile refuses to run, as every instance in the pool would be deleted.
+	AllowDeleteAll bool
+}
+
+// ReconcileReport is the result of comparing the instances GARM expects with the
+// instances that exist in the provider.
+type ReconcileReport struct {
+	// Orphans are instances that exist in the provider, but are not expected by GARM.
+	Orphans []params.ProviderInstance `json:"orphans"`
+	// Missing holds the names of the instances GARM expects, but that don't exist in
+	// the provider.
+	Missing []string `json:"missing"`
+	// Mismatches holds the instances whose status differs from the expected one.
+	Mismatches []StatusMismatch `json:"mismatches"`
+	// OrphanDeletes holds the result of deleting each orphan, if requested.
+	OrphanDeletes []BatchDeleteResult `json:"orphan_deletes,omitempty"`
+}
+
+// Reconcile compares the instances GARM expects in a pool with the ones the provider
+// returns and reports the differences. Instances are matched by provider ID, if the
+// expected instance has one, or by name. Each expected instance matches a single provider
+// instance, so duplicates are reported as orphans. Listing the same name or provider ID
+// more than once in expected is an error. If requested, orphans are deleted.
+func Reconcile(ctx context.Context, provider ExternalProvider, poolID string, expected []ExpectedInstance, opts ReconcileOptions) (ReconcileReport, error) {
+	if opts.DeleteOrphans && len(expected) == 0 && !opts.AllowDeleteAll {
+		return ReconcileReport{}, gErrors.NewBadRequestError("refusing to delete orphans without expected instances, as all instances in the pool would be deleted")
+	}
+
+	byID := map[string]int{}
+	byName := map[string]int{}
+	for i, instance := range expected {
+		if instance.ProviderID != "" {
+			if _, ok := byID[instance.ProviderID]; ok {
+				return ReconcileReport{}, gErrors.NewBadRequestError("expected instance with provider ID %s is listed more than once", instance.ProviderID)
+			}
+			byID[instance.ProviderID] = i
+		} else {
+			if _, ok := byName[instance.Name]; ok {
+				return ReconcileReport{}, gErrors.NewBadRequestError("expected instance %s is listed more than once", instance.Name)
+			}
+			byName[instance.Name] = i
+		}
+	}
+
+	instances, err := provider.ListInstances(ctx, poolID)
+	if err != nil {
+		return ReconcileReport{}, fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	report := ReconcileReport{
+		Orphans:    []params.ProviderInstance{},
+		Missing:    []string{},
+		Mismatches: []StatusMismatch{},
+	}
+
+	// Instances with a known ID are matched first, so an instance that shares its
+	// name is not taken for them.
+	matched := make([]bool, len(expected))
+	var unmatched []params.ProviderInstance
+	for _, instance := range instances {
+		idx, ok := byID[instance.ProviderID]
+		if !ok || matched[idx] {
+			unmatched = append(unmatched, instance)
+			continue
+		}
+		matched[idx] = true
+		report.addMismatch(expected[idx], instance)
+	}
+
+	for _, instance := range unmatched {
+		idx, ok := byName[instance.Name]
+		if !ok || matched[idx] {
+			report.Orphans = append(report.Orphans, instance)
+			continue
+		}
+		matched[idx] = true
+		report.addMismatch(expected[idx], instance)
+	}
+
+	for i, instance := range expected {
+		if !matched[i] {
+			report.Missing = append(report.Missing, instance.Name)
+		}
+	}
+
+	sort.Slice(report.Orphans, func(i, j int) bool {
+		return report.Orphans[i].ProviderID < report.Orphans[j].ProviderID
+	})
+	sort.Strings(report.Missing)
+	sort.Slice(report.Mismatches, func(i, j int) bool {
+		return report.Mismatches[i].Name < report.Mismatches[j].Name
+	})
+
+	if !opts.DeleteOrphans || len(report.Orphans) == 0 {
+		return report, nil
+	}
+
+	orphanIDs := make([]string, len(report.Orphans))
+	for i, orphan := range report.Orphans {
+		orphanIDs[i] = orphan.ProviderID
+	}
+	report.OrphanDeletes, err = DeleteInstances(ctx, provider, orphanIDs, opts.Concurrency)
+	if err != nil {
+		return ReconcileReport{}, fmt.Errorf("failed to delete orphans: %w", err)
+	}
+	return report, nil
+}
+
+// addMismatch records a mismatch if the instance is not in the expected status.
+func (r *ReconcileReport) addMismatch(exp ExpectedInstance, instance params.ProviderInstance) {
+	if exp.Status == "" || exp.Status == instance.Status {
+		return
+	}
+	r.Mismatches = append(r.Mismatches, StatusMismatch{
+		Name:       instance.Name,
+		ProviderID: instance.ProviderID,
+		Expected:   exp.Status,
+		Actual:     instance.Status,
+	})
+}
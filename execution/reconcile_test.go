@@ -0,0 +1,119 @@
+package execution_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/fake"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func newReconcileProvider(t *testing.T) *fake.Provider {
+	provider := fake.NewProvider(fake.Config{})
+	for _, name := range []string{"expected", "stopped", "orphan"} {
+		_, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{Name: name, PoolID: "pool"})
+		require.NoError(t, err)
+	}
+	_, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{Name: "other-pool", PoolID: "other"})
+	require.NoError(t, err)
+	require.NoError(t, provider.SetStatus("fake-2", params.InstanceStopped))
+	return provider
+}
+
+func TestReconcile(t *testing.T) {
+	provider := newReconcileProvider(t)
+	expected := []execution.ExpectedInstance{
+		{Name: "expected"},
+		{Name: "stopped", Status: params.InstanceRunning},
+		{Name: "missing", Status: params.InstanceRunning},
+	}
+
+	report, err := execution.Reconcile(context.Background(), provider, "pool", expected, execution.ReconcileOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Orphans, 1)
+	require.Equal(t, "orphan", report.Orphans[0].Name)
+	require.Equal(t, []string{"missing"}, report.Missing)
+	require.Equal(t, []execution.StatusMismatch{
+		{Name: "stopped", ProviderID: "fake-2", Expected: params.InstanceRunning, Actual: params.InstanceStopped},
+	}, report.Mismatches)
+	require.Empty(t, report.OrphanDeletes)
+	require.Len(t, provider.Instances(), 4)
+}
+
+func TestReconcileDeleteOrphans(t *testing.T) {
+	provider := newReconcileProvider(t)
+	env := execution.Environment{
+		Command:           execution.ReconcileCommand,
+		PoolID:            "pool",
+		InterfaceVersion:  execution.DefaultInterfaceVersion,
+		ExpectedInstances: []execution.ExpectedInstance{{Name: "expected"}, {Name: "stopped"}},
+		DeleteOrphans:     true,
+	}
+
+	out, err := execution.Run(context.Background(), provider, env)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"orphans": [{"provider_id": "fake-3", "name": "orphan", "status": "pending_create"}],
+		"missing": [],
+		"mismatches": [],
+		"orphan_deletes": [{"instance_id": "fake-3"}]
+	}`, out)
+	require.Len(t, provider.Instances(), 3)
+}
+
+func TestReconcileDuplicateNames(t *testing.T) {
+	provider := newReconcileProvider(t)
+	_, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{Name: "expected", PoolID: "pool"})
+	require.NoError(t, err)
+
+	report, err := execution.Reconcile(context.Background(), provider, "pool", []execution.ExpectedInstance{
+		{Name: "expected"},
+		{Name: "stopped"},
+		{Name: "orphan"},
+	}, execution.ReconcileOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Orphans, 1)
+	require.Equal(t, "expected", report.Orphans[0].Name)
+	require.Empty(t, report.Missing)
+
+	// With a known provider ID, the other instance sharing the name is the orphan.
+	report, err = execution.Reconcile(context.Background(), provider, "pool", []execution.ExpectedInstance{
+		{Name: "expected", ProviderID: "fake-5"},
+		{Name: "stopped"},
+		{Name: "orphan"},
+	}, execution.ReconcileOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Orphans, 1)
+	require.Equal(t, "fake-1", report.Orphans[0].ProviderID)
+	require.Empty(t, report.Missing)
+}
+
+func TestReconcileDuplicateExpected(t *testing.T) {
+	provider := newReconcileProvider(t)
+
+	for _, expected := range [][]execution.ExpectedInstance{
+		{{Name: "expected"}, {Name: "expected", Status: params.InstanceRunning}},
+		{{Name: "expected", ProviderID: "fake-1"}, {Name: "other", ProviderID: "fake-1"}},
+	} {
+		_, err := execution.Reconcile(context.Background(), provider, "pool", expected, execution.ReconcileOptions{DeleteOrphans: true})
+		require.Error(t, err)
+		require.Equal(t, execution.ExitCodeBadRequest, execution.ResolveErrorToExitCode(err))
+	}
+	require.Len(t, provider.Instances(), 4)
+}
+
+func TestReconcileRefusesToDeleteAll(t *testing.T) {
+	provider := newReconcileProvider(t)
+
+	_, err := execution.Reconcile(context.Background(), provider, "pool", nil, execution.ReconcileOptions{DeleteOrphans: true})
+	require.Error(t, err)
+	require.Equal(t, execution.ExitCodeBadRequest, execution.ResolveErrorToExitCode(err))
+	require.Len(t, provider.Instances(), 4)
+
+	report, err := execution.Reconcile(context.Background(), provider, "pool", nil, execution.ReconcileOptions{DeleteOrphans: true, AllowDeleteAll: true})
+	require.NoError(t, err)
+	require.Len(t, report.OrphanDeletes, 3)
+	require.Len(t, provider.Instances(), 1)
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// RemoveAllListFunc returns all instances that were created by the given GARM controller,
+// usually by filtering on a tag holding the controller ID.
+type RemoveAllListFunc func(ctx context.Context, controllerID string) ([]params.ProviderInstance, error)
+
+// RemoveAllDeleteFunc deletes a single instance.
+type RemoveAllDeleteFunc func(ctx context.Context, instance params.ProviderInstance) error
+
+// DefaultRemoveAllRetry is the retry config used by RemoveAllInstances if none is set.
+var DefaultRemoveAllRetry = RetryConfig{
+	Attempts:   3,
+	Backoff:    time.Second,
+	MaxBackoff: 10 * time.Second,
+}
+
+// RemoveAllConfig configures RemoveAllInstances.
+type RemoveAllConfig struct {
+	// Concurrency is the number of instances deleted in parallel. Defaults to
+	// DefaultBatchConcurrency.
+	Concurrency int
+	// Retry configures how failed deletes are retried. Defaults to DefaultRemoveAllRetry.
+	Retry RetryConfig
+}
+
+// RemoveAllFailure describes an instance that could not be deleted.
+type RemoveAllFailure struct {
+	Instance params.ProviderInstance
+	Err      error
+}
+
+// RemoveAllReport is the result of RemoveAllInstances.
+type RemoveAllReport struct {
+	// Deleted holds the provider IDs of the instances that were deleted, or that no
+	// longer existed.
+	Deleted []string
+	// Failed holds the instances that could not be deleted.
+	Failed []RemoveAllFailure
+}
+
+// Err returns an error that wraps the errors of all failed instances, or nil if all
+// instances were deleted.
+func (r RemoveAllReport) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+
+	errs := make([]error, len(r.Failed))
+	for i, failure := range r.Failed {
+		errs[i] = fmt.Errorf("failed to delete instance %s: %w", failure.Instance.ProviderID, failure.Err)
+	}
+	return fmt.Errorf("failed to delete %d of %d instances: %w", len(r.Failed), len(r.Failed)+len(r.Deleted), errors.Join(errs...))
+}
+
+// RemoveAllInstances deletes all instances returned by list, using at most cfg.Concurrency
+// parallel calls to del. Deletes that fail with a retryable error are retried. Instances
+// that are already gone (NotFound) are considered deleted, which means a partial run can
+// safely be repeated. An error is only returned if the instances could not be listed. Use
+// the Err() method of the report to check for failed deletes.
+func RemoveAllInstances(ctx context.Context, controllerID string, list RemoveAllListFunc, del RemoveAllDeleteFunc, cfg RemoveAllConfig) (RemoveAllReport, error) {
+	if cfg.Retry.Attempts == 0 {
+		cfg.Retry = DefaultRemoveAllRetry
+	}
+
+	instances, err := list(ctx, controllerID)
+	if err != nil {
+		return RemoveAllReport{}, fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	var (
+		mux    sync.Mutex
+		report RemoveAllReport
+	)
+	forEach(len(instances), cfg.Concurrency, func(i int) {
+		instance := instances[i]
+		err := retry(ctx, cfg.Retry, func() error {
+			return del(ctx, instance)
+		})
+		if isNotFound(err) {
+			err = nil
+		}
+
+		mux.Lock()
+		defer mux.Unlock()
+		if err != nil {
+			report.Failed = append(report.Failed, RemoveAllFailure{
+				Instance: instance,
+				Err:      err,
+			})
+			return
+		}
+		report.Deleted = append(report.Deleted, instance.ProviderID)
+	})
+	return report, nil
+}
@@ -0,0 +1,80 @@
+package execution_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveAllInstances(t *testing.T) {
+	instances := []params.ProviderInstance{
+		{ProviderID: "ok"},
+		{ProviderID: "gone"},
+		{ProviderID: "flaky"},
+		{ProviderID: "denied"},
+	}
+
+	var (
+		mux      sync.Mutex
+		attempts = map[string]int{}
+	)
+	list := func(ctx context.Context, controllerID string) ([]params.ProviderInstance, error) {
+		require.Equal(t, "controller", controllerID)
+		return instances, nil
+	}
+	del := func(ctx context.Context, instance params.ProviderInstance) error {
+		mux.Lock()
+		defer mux.Unlock()
+		attempts[instance.ProviderID]++
+
+		switch instance.ProviderID {
+		case "gone":
+			return gErrors.NewNotFoundError("instance not found")
+		case "flaky":
+			if attempts[instance.ProviderID] < 2 {
+				return gErrors.NewProviderError("transient")
+			}
+		case "denied":
+			return gErrors.NewUnauthorizedError("denied")
+		}
+		return nil
+	}
+
+	report, err := execution.RemoveAllInstances(context.Background(), "controller", list, del, execution.RemoveAllConfig{
+		Concurrency: 2,
+		Retry:       execution.RetryConfig{Attempts: 3, Backoff: time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	sort.Strings(report.Deleted)
+	require.Equal(t, []string{"flaky", "gone", "ok"}, report.Deleted)
+	require.Len(t, report.Failed, 1)
+	require.Equal(t, "denied", report.Failed[0].Instance.ProviderID)
+	require.Equal(t, 2, attempts["flaky"])
+	require.Equal(t, 1, attempts["denied"])
+
+	err = report.Err()
+	var unauthorizedErr *gErrors.UnauthorizedError
+	require.True(t, errors.As(err, &unauthorizedErr))
+}
+
+func TestRemoveAllInstancesListError(t *testing.T) {
+	list := func(ctx context.Context, controllerID string) ([]params.ProviderInstance, error) {
+		return nil, gErrors.NewProviderError("list failed")
+	}
+	del := func(ctx context.Context, instance params.ProviderInstance) error {
+		t.Fatal("delete must not be called")
+		return nil
+	}
+
+	_, err := execution.RemoveAllInstances(context.Background(), "controller", list, del, execution.RemoveAllConfig{})
+	require.Error(t, err)
+}
@@ -0,0 +1,201 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+)
+
+const (
+	// ServerSocketEnvVar is the name of the environment variable that, when set, makes
+	// Main() serve requests on the given unix socket instead of running a single command.
+	ServerSocketEnvVar = "GARM_PROVIDER_SOCKET"
+	// ServerRunPath is the HTTP path on which the server accepts commands.
+	ServerRunPath = "/v1/run"
+
+	// maxServerRequestSize limits the size of a request body. Bootstrap params may hold
+	// a CA bundle and extra specs, but should never come close to this.
+	maxServerRequestSize = 10 * 1024 * 1024
+)
+
+// NewServer returns a new Server that runs commands against the given provider. The
+// controller ID and provider config file of the server take precedence over the ones
+// sent by clients, as a server is tied to one provider configuration. The middlewares, if
+// any, are applied to the provider for every command.
+func NewServer(provider ExternalProvider, controllerID, providerConfigFile string, middlewares ...Middleware) *Server {
+	return &Server{
+		provider:           provider,
+		controllerID:       controllerID,
+		providerConfigFile: providerConfigFile,
+		middlewares:        middlewares,
+	}
+}
+
+// Server serves the ExternalProvider methods over HTTP. Requests are Environments sent
+// as JSON to ServerRunPath. Successful responses hold the same output Run() returns,
+// while failed requests get an ErrorDocument and an HTTP status code that matches the
+// error class.
+type Server struct {
+	provider           ExternalProvider
+	controllerID       string
+	providerConfigFile string
+	middlewares        []Middleware
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != ServerRunPath {
+		writeHTTPError(w, gErrors.NewNotFoundError("unknown path %s", r.URL.Path))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, gErrors.NewBadRequestError("unsupported method %s", r.Method))
+		return
+	}
+
+	var env Environment
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxServerRequestSize)).Decode(&env); err != nil {
+		writeHTTPError(w, gErrors.NewBadRequestError("failed to decode request: %s", err))
+		return
+	}
+	env.ControllerID = s.controllerID
+	env.ProviderConfigFile = s.providerConfigFile
+
+	if err := env.Validate(); err != nil {
+		writeHTTPError(w, gErrors.NewBadRequestError("failed to validate execution environment: %s", err))
+		return
+	}
+
+	timeout, err := GetCommandTimeout(env.Command)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	result, err := Run(ctx, s.provider, env, s.middlewares...)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(result))
+}
+
+// Serve accepts connections on the listener until the context is canceled.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(listener)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
+
+// ServeUnix listens on the given unix socket and serves requests until the context
+// is canceled. A stale socket file left behind by a previous process is removed.
+func (s *Server) ServeUnix(ctx context.Context, socketPath string) error {
+	if fi, err := os.Lstat(socketPath); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	defer os.Remove(socketPath)
+
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	return s.Serve(ctx, listener)
+}
+
+// HTTPStatusFromErrorClass returns the HTTP status code the server uses for the given
+// error class.
+func HTTPStatusFromErrorClass(class ErrorClass) int {
+	switch class {
+	case ErrorClassNotFound:
+		return http.StatusNotFound
+	case ErrorClassDuplicate, ErrorClassConflict:
+		return http.StatusConflict
+	case ErrorClassUnauthorized:
+		return http.StatusUnauthorized
+	case ErrorClassBadRequest:
+		return http.StatusBadRequest
+	case ErrorClassTimeout:
+		return http.StatusGatewayTimeout
+	case ErrorClassProviderError:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	doc := NewErrorDocument(err)
+	asJs, marshalErr := json.Marshal(doc)
+	if marshalErr != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(HTTPStatusFromErrorClass(doc.Class))
+	w.Write(asJs)
+}
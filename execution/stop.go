@@ -0,0 +1,96 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// DefaultGracefulStopPollInterval is the interval at which GracefulStop polls the
+// status of an instance when it is called by Run.
+const DefaultGracefulStopPollInterval = 5 * time.Second
+
+// GracefulStop asks the provider to gracefully shut down an instance and polls its status
+// until it reaches InstanceStopped. If the instance does not stop within the given timeout,
+// it is forcefully stopped. An instance that disappears while waiting is considered stopped.
+// If pollInterval is not positive, DefaultGracefulStopPollInterval is used.
+func GracefulStop(ctx context.Context, provider ExternalProvider, instance string, timeout, pollInterval time.Duration) error {
+	if err := provider.Stop(ctx, instance, false); err != nil {
+		return fmt.Errorf("failed to gracefully stop instance: %w", err)
+	}
+
+	gracefulCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stopped, err := waitForStopped(gracefulCtx, provider, instance, pollInterval)
+	if err != nil {
+		return err
+	}
+	if stopped {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+
+	if err := provider.Stop(ctx, instance, true); err != nil {
+		return fmt.Errorf("failed to forcefully stop instance after %s: %w", timeout, err)
+	}
+	return nil
+}
+
+// waitForStopped polls the status of the instance until it is stopped or the context
+// expires. It returns false if the context expired before the instance stopped.
+func waitForStopped(ctx context.Context, provider ExternalProvider, instance string, pollInterval time.Duration) (bool, error) {
+	if pollInterval <= 0 {
+		pollInterval = DefaultGracefulStopPollInterval
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		inst, err := provider.GetInstance(ctx, instance)
+		switch {
+		case err == nil:
+			if inst.Status == params.InstanceStopped {
+				return true, nil
+			}
+		case isNotFound(err):
+			return true, nil
+		case ctx.Err() != nil:
+			return false, nil
+		case !IsRetryable(err):
+			return false, fmt.Errorf("failed to get instance status: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func isNotFound(err error) bool {
+	var notFoundErr *gErrors.NotFoundError
+	return errors.As(err, &notFoundErr)
+}
@@ -0,0 +1,68 @@
+package execution_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/fake"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+)
+
+// stuckProvider ignores graceful stop requests.
+type stuckProvider struct {
+	*fake.Provider
+	forced []bool
+}
+
+func (p *stuckProvider) Stop(ctx context.Context, instance string, force bool) error {
+	p.forced = append(p.forced, force)
+	if !force {
+		return nil
+	}
+	return p.Provider.Stop(ctx, instance, force)
+}
+
+func TestGracefulStop(t *testing.T) {
+	provider := fake.NewProvider(fake.Config{})
+	instance, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{Name: "test", PoolID: "pool"})
+	require.NoError(t, err)
+
+	err = execution.GracefulStop(context.Background(), provider, instance.ProviderID, time.Second, time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, 1, provider.Calls(execution.StopInstanceCommand))
+	require.Equal(t, params.InstanceStopped, provider.Instances()[0].Status)
+}
+
+func TestGracefulStopEscalatesToForce(t *testing.T) {
+	provider := &stuckProvider{Provider: fake.NewProvider(fake.Config{})}
+	instance, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{Name: "test", PoolID: "pool"})
+	require.NoError(t, err)
+
+	err = execution.GracefulStop(context.Background(), provider, instance.ProviderID, 20*time.Millisecond, time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, []bool{false, true}, provider.forced)
+	require.Equal(t, params.InstanceStopped, provider.Instances()[0].Status)
+}
+
+func TestGracefulStopMissingInstance(t *testing.T) {
+	provider := &stuckProvider{Provider: fake.NewProvider(fake.Config{})}
+
+	err := execution.GracefulStop(context.Background(), provider, "missing", time.Second, time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, []bool{false}, provider.forced)
+}
+
+func TestGracefulStopDefaultPollInterval(t *testing.T) {
+	for _, pollInterval := range []time.Duration{0, -time.Second} {
+		provider := &stuckProvider{Provider: fake.NewProvider(fake.Config{})}
+		instance, err := provider.CreateInstance(context.Background(), params.BootstrapInstance{Name: "test", PoolID: "pool"})
+		require.NoError(t, err)
+
+		err = execution.GracefulStop(context.Background(), provider, instance.ProviderID, 20*time.Millisecond, pollInterval)
+		require.NoError(t, err)
+		require.Equal(t, []bool{false, true}, provider.forced)
+	}
+}
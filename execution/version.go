@@ -0,0 +1,213 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package execution
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+const (
+	// InterfaceVersion010 is the original interface between GARM and external providers.
+	// It is assumed if GARM_INTERFACE_VERSION is not set.
+	InterfaceVersion010 = "v0.1.0"
+	// InterfaceVersion011 adds the GARM_POOL_EXTRASPECS environment variable, which holds
+	// the base64 encoded extra specs of the pool, for all commands.
+	InterfaceVersion011 = "v0.1.1"
+
+	// DefaultInterfaceVersion is the interface version assumed when GARM does not set one.
+	DefaultInterfaceVersion = InterfaceVersion010
+)
+
+// SupportedInterfaceVersions is the list of interface versions this package knows how
+// to decode and encode, from the oldest to the newest.
+var SupportedInterfaceVersions = []string{
+	InterfaceVersion010,
+	InterfaceVersion011,
+}
+
+// VersionProvider is an optional interface that providers can implement to report
+// their own version.
+type VersionProvider interface {
+	// GetVersion returns the version of the provider.
+	GetVersion(ctx context.Context) (string, error)
+}
+
+// InterfaceVersionProvider is an optional interface that providers can implement to
+// declare which interface versions they support. Providers that don't implement it
+// are assumed to support all versions in SupportedInterfaceVersions.
+type InterfaceVersionProvider interface {
+	// GetSupportedInterfaceVersions returns the interface versions the provider supports.
+	GetSupportedInterfaceVersions(ctx context.Context) ([]string, error)
+}
+
+// interfaceCodec handles the version specific parts of the wire protocol between GARM
+// and a provider.
+type interfaceCodec interface {
+	// decodeEnvironment reads any version specific environment variables into env.
+	decodeEnvironment(env *Environment, getenv func(string) string) error
+	// encodeEnvironment returns the version specific environment variables for env.
+	encodeEnvironment(env Environment) []string
+	// decodeBootstrapParams decodes the bootstrap params passed in via stdin.
+	decodeBootstrapParams(data []byte) (params.BootstrapInstance, error)
+	// encodeBootstrapParams serializes the bootstrap params so they can be passed in via stdin.
+	encodeBootstrapParams(bootstrapParams params.BootstrapInstance) ([]byte, error)
+	// encode serializes a command result so it can be written to stdout.
+	encode(v interface{}) (string, error)
+	// decode deserializes a command result read from stdout.
+	decode(data []byte, v interface{}) error
+}
+
+type codecV010 struct{}
+
+func (c codecV010) decodeEnvironment(env *Environment, getenv func(string) string) error {
+	return nil
+}
+
+func (c codecV010) encodeEnvironment(env Environment) []string {
+	return nil
+}
+
+func (c codecV010) decodeBootstrapParams(data []byte) (params.BootstrapInstance, error) {
+	var bootstrapParams params.BootstrapInstance
+	if err := json.Unmarshal(data, &bootstrapParams); err != nil {
+		return params.BootstrapInstance{}, fmt.Errorf("failed to decode instance params: %w", err)
+	}
+	return bootstrapParams, nil
+}
+
+func (c codecV010) encodeBootstrapParams(bootstrapParams params.BootstrapInstance) ([]byte, error) {
+	asJs, err := json.Marshal(bootstrapParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode instance params: %w", err)
+	}
+	return asJs, nil
+}
+
+func (c codecV010) encode(v interface{}) (string, error) {
+	asJs, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal response: %w", err)
+	}
+	return string(asJs), nil
+}
+
+func (c codecV010) decode(data []byte, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+type codecV011 struct {
+	codecV010
+}
+
+func (c codecV011) decodeEnvironment(env *Environment, getenv func(string) string) error {
+	val := getenv("GARM_POOL_EXTRASPECS")
+	if val == "" {
+		return nil
+	}
+
+	extraSpecs, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return fmt.Errorf("failed to decode GARM_POOL_EXTRASPECS: %w", err)
+	}
+
+	if !json.Valid(extraSpecs) {
+		return fmt.Errorf("GARM_POOL_EXTRASPECS is not valid json")
+	}
+	env.ExtraSpecs = extraSpecs
+	return nil
+}
+
+func (c codecV011) encodeEnvironment(env Environment) []string {
+	if len(env.ExtraSpecs) == 0 {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("GARM_POOL_EXTRASPECS=%s", base64.StdEncoding.EncodeToString(env.ExtraSpecs)),
+	}
+}
+
+var interfaceCodecs = map[string]interfaceCodec{
+	InterfaceVersion010: codecV010{},
+	InterfaceVersion011: codecV011{},
+}
+
+func getInterfaceCodec(version string) (interfaceCodec, error) {
+	if version == "" {
+		version = DefaultInterfaceVersion
+	}
+
+	codec, ok := interfaceCodecs[version]
+	if !ok {
+		return nil, gErrors.NewBadRequestError("unsupported interface version: %s", version)
+	}
+	return codec, nil
+}
+
+// isVersionNegotiationCommand returns true for the commands GARM uses to negotiate the
+// interface version. They must work regardless of the version that was requested.
+func isVersionNegotiationCommand(command ExecutionCommand) bool {
+	switch command {
+	case GetVersionCommand, GetSupportedInterfaceVersionsCommand:
+		return true
+	}
+	return false
+}
+
+// getCommandCodec returns the codec used to run the given command. The version negotiation
+// commands fall back to the default codec if the requested version is not known.
+func getCommandCodec(command ExecutionCommand, version string) (interfaceCodec, error) {
+	codec, err := getInterfaceCodec(version)
+	if err != nil && isVersionNegotiationCommand(command) {
+		return interfaceCodecs[DefaultInterfaceVersion], nil
+	}
+	return codec, err
+}
+
+func getProviderInterfaceVersions(ctx context.Context, provider ExternalProvider) ([]string, error) {
+	if versionProvider, ok := provider.(InterfaceVersionProvider); ok {
+		versions, err := versionProvider.GetSupportedInterfaceVersions(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get supported interface versions: %w", err)
+		}
+		return versions, nil
+	}
+	return SupportedInterfaceVersions, nil
+}
+
+func providerSupportsInterfaceVersion(ctx context.Context, provider ExternalProvider, version string) (bool, error) {
+	if version == "" {
+		version = DefaultInterfaceVersion
+	}
+
+	versions, err := getProviderInterfaceVersions(ctx, provider)
+	if err != nil {
+		return false, err
+	}
+	for _, val := range versions {
+		if val == version {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,376 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package extraspecs implements a registry of typed extra specs. Providers register the
+// structs (or JSON schemas) that describe the extra specs they accept. The registry is
+// then used to strictly decode and validate the extra specs of a pool, reporting unknown
+// keys instead of silently ignoring them.
+package extraspecs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ValidationError is returned when extra specs don't match the registered specs.
+type ValidationError struct {
+	// UnknownKeys holds the top level keys that are not owned by any registered spec.
+	UnknownKeys []string `json:"unknown_keys,omitempty"`
+	// Errors holds the errors found while decoding the registered specs.
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (v *ValidationError) Error() string {
+	var msgs []string
+	if len(v.UnknownKeys) > 0 {
+		msgs = append(msgs, fmt.Sprintf("unknown keys: %s", strings.Join(v.UnknownKeys, ", ")))
+	}
+	msgs = append(msgs, v.Errors...)
+	return strings.Join(msgs, "; ")
+}
+
+type spec struct {
+	name string
+	keys []string
+
+	// typ and defaults are set for specs registered with Register. The defaults are
+	// kept JSON encoded, so each decode starts from a deep copy of them and can't
+	// modify the maps and slices of the registered value.
+	typ      reflect.Type
+	defaults []byte
+
+	// schema is set for specs registered with RegisterSchema.
+	schema *objectSchema
+}
+
+// Registry holds the extra specs known to a provider. Each registered spec owns a set of
+// top level keys. It is safe for concurrent use.
+type Registry struct {
+	mux   sync.RWMutex
+	specs map[string]*spec
+	owner map[string]string
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		specs: map[string]*spec{},
+		owner: map[string]string{},
+	}
+}
+
+// Register adds a typed spec to the registry. The defaults argument must be a struct
+// (or a pointer to one) holding the default values of the spec. The top level keys of
+// the spec are taken from the JSON tags of the struct. Only the fields that are encoded
+// to JSON keep their default value.
+func (r *Registry) Register(name string, defaults interface{}) error {
+	typ := reflect.TypeOf(defaults)
+	if typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+		defaults = reflect.ValueOf(defaults).Elem().Interface()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("spec %s must be a struct", name)
+	}
+
+	encoded, err := json.Marshal(defaults)
+	if err != nil {
+		return fmt.Errorf("failed to encode defaults of spec %s: %w", name, err)
+	}
+
+	return r.add(&spec{
+		name:     name,
+		keys:     structKeys(typ),
+		typ:      typ,
+		defaults: encoded,
+	})
+}
+
+// RegisterSchema adds a spec described by a JSON schema to the registry. Only the top
+// level properties of the schema are validated: their presence if they are required,
+// their type and their allowed values (enum).
+func (r *Registry) RegisterSchema(name string, schema []byte) error {
+	var parsed objectSchema
+	if err := json.Unmarshal(schema, &parsed); err != nil {
+		return fmt.Errorf("failed to parse schema of spec %s: %w", name, err)
+	}
+	if len(parsed.Properties) == 0 {
+		return fmt.Errorf("schema of spec %s has no properties", name)
+	}
+
+	keys := make([]string, 0, len(parsed.Properties))
+	for key := range parsed.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return r.add(&spec{
+		name:   name,
+		keys:   keys,
+		schema: &parsed,
+	})
+}
+
+func (r *Registry) add(s *spec) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.specs[s.name]; ok {
+		return fmt.Errorf("spec %s is already registered", s.name)
+	}
+	for _, key := range s.keys {
+		if owner, ok := r.owner[key]; ok {
+			return fmt.Errorf("key %s of spec %s is already owned by spec %s", key, s.name, owner)
+		}
+	}
+
+	r.specs[s.name] = s
+	for _, key := range s.keys {
+		r.owner[key] = s.name
+	}
+	return nil
+}
+
+// Keys returns all top level keys known to the registry, sorted.
+func (r *Registry) Keys() []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	keys := make([]string, 0, len(r.owner))
+	for key := range r.owner {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UnknownKeys returns the top level keys of the extra specs that are not owned by any
+// registered spec.
+func (r *Registry) UnknownKeys(extraSpecs json.RawMessage) ([]string, error) {
+	fields, err := splitObject(extraSpecs)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	unknown := []string{}
+	for key := range fields {
+		if _, ok := r.owner[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// Validate checks the extra specs against all registered specs. It returns a
+// *ValidationError listing the unknown keys and the decoding errors, if any.
+func (r *Registry) Validate(extraSpecs json.RawMessage) error {
+	fields, err := splitObject(extraSpecs)
+	if err != nil {
+		return &ValidationError{Errors: []string{err.Error()}}
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	validationErr := &ValidationError{}
+	for key := range fields {
+		if _, ok := r.owner[key]; !ok {
+			validationErr.UnknownKeys = append(validationErr.UnknownKeys, key)
+		}
+	}
+	sort.Strings(validationErr.UnknownKeys)
+
+	names := make([]string, 0, len(r.specs))
+	for name := range r.specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := r.specs[name]
+		if s.schema != nil {
+			validationErr.Errors = append(validationErr.Errors, s.schema.validate(name, fields)...)
+			continue
+		}
+
+		target := reflect.New(s.typ)
+		if err := json.Unmarshal(s.defaults, target.Interface()); err != nil {
+			validationErr.Errors = append(validationErr.Errors, fmt.Sprintf("%s: failed to apply defaults: %s", name, err))
+			continue
+		}
+		if err := decodeStrict(fields, s.keys, target.Interface()); err != nil {
+			validationErr.Errors = append(validationErr.Errors, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+
+	if len(validationErr.UnknownKeys) == 0 && len(validationErr.Errors) == 0 {
+		return nil
+	}
+	return validationErr
+}
+
+// Decode strictly decodes the keys owned by the named spec into v, which must be a
+// pointer to the registered type. The registered defaults are applied first, so values
+// set in the extra specs override them. Nested unknown fields are reported as errors.
+// Keys owned by other specs are ignored.
+func (r *Registry) Decode(extraSpecs json.RawMessage, name string, v interface{}) error {
+	r.mux.RLock()
+	s, ok := r.specs[name]
+	r.mux.RUnlock()
+	if !ok {
+		return fmt.Errorf("spec %s is not registered", name)
+	}
+	if s.typ == nil {
+		return fmt.Errorf("spec %s was registered as a schema and can't be decoded", name)
+	}
+
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.Elem().Type() != s.typ {
+		return fmt.Errorf("expected a pointer to %s, got %T", s.typ, v)
+	}
+
+	fields, err := splitObject(extraSpecs)
+	if err != nil {
+		return err
+	}
+
+	target.Elem().Set(reflect.Zero(s.typ))
+	if err := json.Unmarshal(s.defaults, v); err != nil {
+		return fmt.Errorf("failed to apply defaults of spec %s: %w", name, err)
+	}
+	if err := decodeStrict(fields, s.keys, v); err != nil {
+		return fmt.Errorf("failed to decode spec %s: %w", name, err)
+	}
+	return nil
+}
+
+// Merge merges the overrides into the base extra specs, for example the pool level extra
+// specs into the defaults of a provider. Objects are merged recursively. Any other value
+// in overrides replaces the value in base. A null override removes the key.
+func Merge(base, overrides json.RawMessage) (json.RawMessage, error) {
+	var baseVal, overridesVal interface{}
+	if len(bytes.TrimSpace(base)) > 0 {
+		if err := json.Unmarshal(base, &baseVal); err != nil {
+			return nil, fmt.Errorf("failed to decode base extra specs: %w", err)
+		}
+	}
+	if len(bytes.TrimSpace(overrides)) > 0 {
+		if err := json.Unmarshal(overrides, &overridesVal); err != nil {
+			return nil, fmt.Errorf("failed to decode extra specs overrides: %w", err)
+		}
+	}
+
+	merged, err := json.Marshal(mergeValues(baseVal, overridesVal))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode merged extra specs: %w", err)
+	}
+	return merged, nil
+}
+
+func mergeValues(base, overrides interface{}) interface{} {
+	baseMap, baseIsMap := base.(map[string]interface{})
+	overridesMap, overridesIsMap := overrides.(map[string]interface{})
+	if !baseIsMap || !overridesIsMap {
+		if overrides == nil {
+			return base
+		}
+		return overrides
+	}
+
+	ret := make(map[string]interface{}, len(baseMap))
+	for key, val := range baseMap {
+		ret[key] = val
+	}
+	for key, val := range overridesMap {
+		if val == nil {
+			delete(ret, key)
+			continue
+		}
+		ret[key] = mergeValues(ret[key], val)
+	}
+	return ret
+}
+
+// splitObject decodes the top level keys of the extra specs. Empty extra specs are
+// treated as an empty object.
+func splitObject(extraSpecs json.RawMessage) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if len(bytes.TrimSpace(extraSpecs)) == 0 {
+		return fields, nil
+	}
+	if err := json.Unmarshal(extraSpecs, &fields); err != nil {
+		return nil, fmt.Errorf("extra specs must be a JSON object: %w", err)
+	}
+	if fields == nil {
+		fields = map[string]json.RawMessage{}
+	}
+	return fields, nil
+}
+
+// decodeStrict decodes the given keys of fields into v, rejecting unknown nested fields.
+func decodeStrict(fields map[string]json.RawMessage, keys []string, v interface{}) error {
+	subset := map[string]json.RawMessage{}
+	for _, key := range keys {
+		if val, ok := fields[key]; ok {
+			subset[key] = val
+		}
+	}
+	if len(subset) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(subset)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// structKeys returns the JSON keys of a struct, including the keys of embedded structs.
+func structKeys(typ reflect.Type) []string {
+	var keys []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			keys = append(keys, structKeys(field.Type)...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
@@ -0,0 +1,141 @@
+package extraspecs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testSpec struct {
+	Flavor   string            `json:"flavor"`
+	DiskSize int               `json:"disk_size"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Network  *testNetwork      `json:"network,omitempty"`
+}
+
+type testNetwork struct {
+	Subnet string `json:"subnet"`
+}
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"image": {"type": "string"},
+		"tier": {"type": "string", "enum": ["gold", "silver"]},
+		"spot": {"type": ["boolean", "null"]}
+	},
+	"required": ["image"]
+}`
+
+func newTestRegistry(t *testing.T) *Registry {
+	registry := NewRegistry()
+	require.NoError(t, registry.Register("compute", testSpec{Flavor: "small", DiskSize: 20}))
+	require.NoError(t, registry.RegisterSchema("image", []byte(testSchema)))
+	return registry
+}
+
+func TestRegister(t *testing.T) {
+	registry := newTestRegistry(t)
+	require.Equal(t, []string{"disk_size", "flavor", "image", "network", "spot", "tags", "tier"}, registry.Keys())
+
+	require.EqualError(t, registry.Register("compute", testNetwork{}), "spec compute is already registered")
+	require.EqualError(t, registry.Register("other", &struct {
+		Flavor string `json:"flavor"`
+	}{}), "key flavor of spec other is already owned by spec compute")
+	require.EqualError(t, registry.Register("invalid", "flavor"), "spec invalid must be a struct")
+	require.Error(t, registry.RegisterSchema("broken", []byte(`{`)))
+}
+
+func TestValidate(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	require.NoError(t, registry.Validate(json.RawMessage(`{"flavor": "large", "image": "ubuntu", "tier": "gold"}`)))
+
+	err := registry.Validate(json.RawMessage(`{
+		"flavour": "large",
+		"disk_size": "big",
+		"network": {"subnet": "a", "vlan": 10},
+		"tier": "bronze",
+		"spot": 1
+	}`))
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Equal(t, []string{"flavour"}, validationErr.UnknownKeys)
+	require.Len(t, validationErr.Errors, 4)
+	require.Contains(t, validationErr.Errors[0], "compute:")
+	require.Equal(t, "image: missing required key image", validationErr.Errors[1])
+	require.Equal(t, "image: spot: expected [boolean null], got integer", validationErr.Errors[2])
+	require.Equal(t, `image: tier: value "bronze" is not allowed`, validationErr.Errors[3])
+
+	err = registry.Validate(json.RawMessage(`[]`))
+	require.ErrorAs(t, err, &validationErr)
+}
+
+func TestUnknownKeys(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	unknown, err := registry.UnknownKeys(json.RawMessage(`{"flavor": "large", "pre_instal_scripts": {}}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"pre_instal_scripts"}, unknown)
+
+	unknown, err = registry.UnknownKeys(nil)
+	require.NoError(t, err)
+	require.Empty(t, unknown)
+}
+
+func TestDecode(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	var spec testSpec
+	require.NoError(t, registry.Decode(json.RawMessage(`{"flavor": "large", "image": "ubuntu"}`), "compute", &spec))
+	require.Equal(t, testSpec{Flavor: "large", DiskSize: 20}, spec)
+
+	require.NoError(t, registry.Decode(nil, "compute", &spec))
+	require.Equal(t, testSpec{Flavor: "small", DiskSize: 20}, spec)
+
+	require.Error(t, registry.Decode(json.RawMessage(`{"network": {"vlan": 10}}`), "compute", &spec))
+	require.EqualError(t, registry.Decode(nil, "missing", &spec), "spec missing is not registered")
+	require.EqualError(t, registry.Decode(nil, "image", &spec), "spec image was registered as a schema and can't be decoded")
+	require.Error(t, registry.Decode(nil, "compute", &testNetwork{}))
+}
+
+type collectionSpec struct {
+	Tags []string          `json:"tags"`
+	Ctx  map[string]string `json:"ctx"`
+}
+
+func TestDecodeDoesNotModifyDefaults(t *testing.T) {
+	registry := NewRegistry()
+	defaults := collectionSpec{
+		Tags: make([]string, 0, 4),
+		Ctx:  map[string]string{"a": "1"},
+	}
+	require.NoError(t, registry.Register("collections", defaults))
+
+	var spec collectionSpec
+	require.NoError(t, registry.Decode(json.RawMessage(`{"tags": ["user"], "ctx": {"b": "2"}}`), "collections", &spec))
+	require.Equal(t, collectionSpec{Tags: []string{"user"}, Ctx: map[string]string{"a": "1", "b": "2"}}, spec)
+	require.NoError(t, registry.Validate(json.RawMessage(`{"tags": ["other"], "ctx": {"c": "3"}}`)))
+
+	var second collectionSpec
+	require.NoError(t, registry.Decode(json.RawMessage(`{}`), "collections", &second))
+	require.Equal(t, collectionSpec{Tags: []string{}, Ctx: map[string]string{"a": "1"}}, second)
+	require.Equal(t, map[string]string{"a": "1"}, defaults.Ctx)
+}
+
+func TestMerge(t *testing.T) {
+	merged, err := Merge(
+		json.RawMessage(`{"flavor": "small", "tags": {"team": "ci", "env": "dev"}, "disk_size": 20}`),
+		json.RawMessage(`{"flavor": "large", "tags": {"env": "prod"}, "disk_size": null}`),
+	)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"flavor": "large", "tags": {"team": "ci", "env": "prod"}}`, string(merged))
+
+	merged, err = Merge(json.RawMessage(`{"flavor": "small"}`), nil)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"flavor": "small"}`, string(merged))
+
+	_, err = Merge(json.RawMessage(`{`), nil)
+	require.Error(t, err)
+}
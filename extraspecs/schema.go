@@ -0,0 +1,148 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package extraspecs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// objectSchema is the subset of JSON Schema supported by RegisterSchema.
+type objectSchema struct {
+	Properties map[string]propertySchema `json:"properties"`
+	Required   []string                  `json:"required,omitempty"`
+}
+
+type propertySchema struct {
+	// Type is either a single type name or a list of type names.
+	Type interface{}       `json:"type,omitempty"`
+	Enum []json.RawMessage `json:"enum,omitempty"`
+}
+
+func (s *objectSchema) validate(name string, fields map[string]json.RawMessage) []string {
+	var errs []string
+	for _, key := range s.Required {
+		if _, ok := fields[key]; !ok {
+			errs = append(errs, fmt.Sprintf("%s: missing required key %s", name, key))
+		}
+	}
+
+	keys := make([]string, 0, len(s.Properties))
+	for key := range s.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		val, ok := fields[key]
+		if !ok {
+			continue
+		}
+		if err := s.Properties[key].validate(val); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s: %s", name, key, err))
+		}
+	}
+	return errs
+}
+
+func (p propertySchema) validate(val json.RawMessage) error {
+	types := p.types()
+	if len(types) > 0 {
+		actual := jsonType(val)
+		var found bool
+		for _, typ := range types {
+			if typ == actual || (typ == "number" && actual == "integer") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("expected %v, got %s", types, actual)
+		}
+	}
+
+	if len(p.Enum) == 0 {
+		return nil
+	}
+	compacted, err := compact(val)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range p.Enum {
+		allowedCompacted, err := compact(allowed)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(compacted, allowedCompacted) {
+			return nil
+		}
+	}
+	return fmt.Errorf("value %s is not allowed", compacted)
+}
+
+func (p propertySchema) types() []string {
+	switch typ := p.Type.(type) {
+	case string:
+		return []string{typ}
+	case []interface{}:
+		var ret []string
+		for _, val := range typ {
+			if asStr, ok := val.(string); ok {
+				ret = append(ret, asStr)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+// jsonType returns the JSON Schema type name of a JSON value.
+func jsonType(val json.RawMessage) string {
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(val))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return "invalid"
+	}
+
+	switch v := decoded.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "invalid"
+}
+
+func compact(val json.RawMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, val); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return buf.Bytes(), nil
+}
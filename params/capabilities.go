@@ -0,0 +1,102 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package params
+
+import "encoding/json"
+
+// ProviderFeature is an optional feature a provider may support.
+type ProviderFeature string
+
+const (
+	// FeatureStartStop indicates that the provider can start and stop instances.
+	FeatureStartStop ProviderFeature = "start_stop"
+	// FeatureJITConfig indicates that the provider can bootstrap runners using
+	// just-in-time configuration.
+	FeatureJITConfig ProviderFeature = "jit_config"
+	// FeatureSSHKeys indicates that the provider injects the SSH keys sent in the
+	// bootstrap params into the instances it creates.
+	FeatureSSHKeys ProviderFeature = "ssh_keys"
+	// FeatureCACertBundle indicates that the provider installs the CA certificate
+	// bundle sent in the bootstrap params.
+	FeatureCACertBundle ProviderFeature = "ca_cert_bundle"
+	// FeaturePreInstallScripts indicates that the provider runs the pre-install
+	// scripts defined in the extra specs.
+	FeaturePreInstallScripts ProviderFeature = "pre_install_scripts"
+)
+
+// ProviderCapabilities describes what a provider is able to do. It is returned by
+// providers as a response to the GetCapabilities command.
+type ProviderCapabilities struct {
+	// OSTypes is the list of operating system types the provider can create.
+	OSTypes []OSType `json:"os_types"`
+	// OSArches is the list of CPU architectures the provider can create.
+	OSArches []OSArch `json:"os_arches"`
+	// Features is the list of optional features the provider supports.
+	Features []ProviderFeature `json:"features"`
+	// ExtraSpecsKeys is the list of top level extra specs keys the provider
+	// accepts.
+	ExtraSpecsKeys []string `json:"extra_specs_keys"`
+}
+
+// MarshalJSON implements json.Marshaler. Empty lists are always serialized as
+// empty JSON arrays, so consumers never need to handle null values.
+func (p ProviderCapabilities) MarshalJSON() ([]byte, error) {
+	type alias ProviderCapabilities
+	ret := alias(p)
+	if ret.OSTypes == nil {
+		ret.OSTypes = []OSType{}
+	}
+	if ret.OSArches == nil {
+		ret.OSArches = []OSArch{}
+	}
+	if ret.Features == nil {
+		ret.Features = []ProviderFeature{}
+	}
+	if ret.ExtraSpecsKeys == nil {
+		ret.ExtraSpecsKeys = []string{}
+	}
+	return json.Marshal(ret)
+}
+
+// HasFeature returns true if the provider declared support for the given feature.
+func (p ProviderCapabilities) HasFeature(feature ProviderFeature) bool {
+	for _, val := range p.Features {
+		if val == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsOSType returns true if the provider can create instances of the given OS type.
+func (p ProviderCapabilities) SupportsOSType(osType OSType) bool {
+	for _, val := range p.OSTypes {
+		if val == osType {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsOSArch returns true if the provider can create instances with the given
+// CPU architecture.
+func (p ProviderCapabilities) SupportsOSArch(osArch OSArch) bool {
+	for _, val := range p.OSArches {
+		if val == osArch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package params
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// JSONSchemaDialect is the JSON Schema dialect of the generated schemas.
+	JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+	// JSONSchemaBaseID is the prefix of the $id of the generated schemas.
+	JSONSchemaBaseID = "https://github.com/cloudbase/garm-provider-common/params/schema/"
+)
+
+//go:embed schema/*.json
+var schemaFS embed.FS
+
+// SchemaTypes maps the name of the wire types that have a published schema to a value
+// of that type.
+var SchemaTypes = map[string]interface{}{
+	"BootstrapInstance":         BootstrapInstance{},
+	"ProviderInstance":          ProviderInstance{},
+	"Address":                   Address{},
+	"RunnerApplicationDownload": RunnerApplicationDownload{},
+}
+
+// schemaEnums holds the allowed values of the string types that are enumerations. They
+// must match what the IsValid method of each type accepts.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(OSType("")):         {string(Linux), string(Windows)},
+	reflect.TypeOf(OSArch("")):         {string(Amd64), string(I386), string(Arm64), string(Arm)},
+	reflect.TypeOf(AddressType("")):    {string(PublicAddress), string(PrivateAddress)},
+	reflect.TypeOf(InstanceStatus("")): instanceStatusNames(),
+}
+
+// schemaRequired holds the required fields of the wire types. It must match what the
+// Validate method of each type enforces, as GARM and providers don't always set the other
+// fields.
+var schemaRequired = map[reflect.Type][]string{
+	reflect.TypeOf(BootstrapInstance{}): {"name", "os_type", "arch"},
+	reflect.TypeOf(Address{}):           {"address", "type"},
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func instanceStatusNames() []string {
+	names := []string{string(InstanceStatusUnknown)}
+	for status := range instanceStatusTransitions {
+		names = append(names, string(status))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JSONSchema returns the committed JSON Schema of one of the wire types listed in
+// SchemaTypes (eg: "BootstrapInstance").
+func JSONSchema(name string) ([]byte, error) {
+	if _, ok := SchemaTypes[name]; !ok {
+		return nil, fmt.Errorf("no schema for type %s", name)
+	}
+	return schemaFS.ReadFile(SchemaFileName(name))
+}
+
+// SchemaFileName returns the path, relative to the params package, of the file holding
+// the schema of the given type.
+func SchemaFileName(name string) string {
+	return fmt.Sprintf("schema/%s.json", name)
+}
+
+// GenerateJSONSchema generates the JSON Schema of v, based on its JSON encoding. Only the
+// fields in schemaRequired are required, and unknown fields are allowed, so that older
+// consumers keep accepting payloads with new fields. Nested structs are described in $defs.
+func GenerateJSONSchema(v interface{}) ([]byte, error) {
+	typ := reflect.TypeOf(v)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("schemas can only be generated for structs")
+	}
+
+	gen := &schemaGenerator{
+		defs: map[string]*jsonSchema{},
+	}
+	root := gen.structSchema(typ)
+	root.Dialect = JSONSchemaDialect
+	root.ID = JSONSchemaBaseID + typ.Name() + ".json"
+	root.Title = typ.Name()
+	if len(gen.defs) > 0 {
+		root.Defs = gen.defs
+	}
+
+	asJs, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return append(asJs, '\n'), nil
+}
+
+type jsonSchema struct {
+	Dialect              string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+type schemaGenerator struct {
+	defs map[string]*jsonSchema
+}
+
+func (g *schemaGenerator) structSchema(typ reflect.Type) *jsonSchema {
+	ret := &jsonSchema{
+		Type:       "object",
+		Properties: map[string]*jsonSchema{},
+		Required:   schemaRequired[typ],
+	}
+	g.addFields(ret, typ)
+	return ret
+}
+
+func (g *schemaGenerator) addFields(s *jsonSchema, typ reflect.Type) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			g.addFields(s, field.Type)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		s.Properties[name] = g.typeSchema(field.Type, !strings.Contains(opts, "omitempty"))
+	}
+}
+
+// typeSchema returns the schema of a type. If nullable is true, the schema also allows
+// null for the types that encode their zero value as null.
+func (g *schemaGenerator) typeSchema(typ reflect.Type, nullable bool) *jsonSchema {
+	if typ == rawMessageType {
+		return &jsonSchema{}
+	}
+
+	var ret *jsonSchema
+	switch typ.Kind() {
+	case reflect.Pointer:
+		ret = g.typeSchema(typ.Elem(), false)
+	case reflect.Struct:
+		if _, ok := g.defs[typ.Name()]; !ok {
+			// Reserve the name before recursing, to handle self referencing types.
+			g.defs[typ.Name()] = nil
+			g.defs[typ.Name()] = g.structSchema(typ)
+		}
+		return &jsonSchema{Ref: "#/$defs/" + typ.Name()}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			ret = &jsonSchema{Type: "string", ContentEncoding: "base64"}
+		} else {
+			ret = &jsonSchema{Type: "array", Items: g.typeSchema(typ.Elem(), false)}
+		}
+	case reflect.Map:
+		ret = &jsonSchema{Type: "object", AdditionalProperties: g.typeSchema(typ.Elem(), false)}
+	case reflect.String:
+		ret = &jsonSchema{Type: "string", Enum: schemaEnums[typ]}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	default:
+		return &jsonSchema{}
+	}
+
+	switch typ.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		if !nullable {
+			return ret
+		}
+		if ret.Ref != "" {
+			return &jsonSchema{AnyOf: []*jsonSchema{ret, {Type: "null"}}}
+		}
+		if t, ok := ret.Type.(string); ok {
+			ret.Type = []string{t, "null"}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,81 @@
+package params
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateSchemas = flag.Bool("update", false, "update the committed JSON schemas")
+
+// TestSchemasUpToDate fails if the wire types changed without regenerating the schemas.
+// Run "go test ./params -run TestSchemasUpToDate -update" to regenerate them.
+func TestSchemasUpToDate(t *testing.T) {
+	for name, v := range SchemaTypes {
+		generated, err := GenerateJSONSchema(v)
+		require.NoError(t, err)
+
+		if *updateSchemas {
+			require.NoError(t, os.WriteFile(SchemaFileName(name), generated, 0o644))
+			continue
+		}
+
+		committed, err := JSONSchema(name)
+		require.NoError(t, err)
+		require.Equal(t, string(committed), string(generated), "schema of %s is out of date, run the tests with -update", name)
+	}
+}
+
+func TestGenerateJSONSchema(t *testing.T) {
+	data, err := GenerateJSONSchema(ProviderInstance{})
+	require.NoError(t, err)
+
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &schema))
+	require.Equal(t, JSONSchemaDialect, schema["$schema"])
+	require.Equal(t, "object", schema["type"])
+
+	properties := schema["properties"].(map[string]interface{})
+	status := properties["status"].(map[string]interface{})
+	require.Contains(t, status["enum"], string(InstanceRunning))
+
+	addresses := properties["addresses"].(map[string]interface{})
+	require.Equal(t, "#/$defs/Address", addresses["items"].(map[string]interface{})["$ref"])
+
+	_, hasRequired := schema["required"]
+	require.False(t, hasRequired)
+	_, hasAdditionalProperties := schema["additionalProperties"]
+	require.False(t, hasAdditionalProperties)
+
+	data, err = GenerateJSONSchema(BootstrapInstance{})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &schema))
+	require.Equal(t, []interface{}{"name", "os_type", "arch"}, schema["required"])
+
+	_, err = GenerateJSONSchema("not a struct")
+	require.Error(t, err)
+}
+
+func TestSchemaEnumsMatchValidate(t *testing.T) {
+	for _, val := range schemaEnums[reflect.TypeOf(OSType(""))] {
+		require.True(t, OSType(val).IsValid(), val)
+	}
+	for _, val := range schemaEnums[reflect.TypeOf(OSArch(""))] {
+		require.True(t, OSArch(val).IsValid(), val)
+	}
+	for _, val := range schemaEnums[reflect.TypeOf(AddressType(""))] {
+		require.True(t, AddressType(val).IsValid(), val)
+	}
+	for _, val := range schemaEnums[reflect.TypeOf(InstanceStatus(""))] {
+		require.True(t, InstanceStatus(val).IsValid(), val)
+	}
+}
+
+func TestJSONSchemaUnknownType(t *testing.T) {
+	_, err := JSONSchema("Environment")
+	require.Error(t, err)
+}
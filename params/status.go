@@ -0,0 +1,129 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package params
+
+import "strings"
+
+// instanceStatusTransitions holds the statuses an instance can move to from a given
+// status. Moving to the same status, or to or from InstanceStatusUnknown, is always
+// allowed and is not listed here.
+var instanceStatusTransitions = map[InstanceStatus][]InstanceStatus{
+	InstancePendingCreate: {
+		InstanceCreating, InstanceRunning, InstanceError,
+		InstancePendingDelete, InstancePendingForceDelete, InstanceDeleting,
+	},
+	InstanceCreating: {
+		InstanceRunning, InstanceStopped, InstanceError,
+		InstancePendingDelete, InstancePendingForceDelete, InstanceDeleting,
+	},
+	InstanceRunning: {
+		InstanceStopped, InstanceError,
+		InstancePendingDelete, InstancePendingForceDelete, InstanceDeleting,
+	},
+	InstanceStopped: {
+		InstanceRunning, InstanceError,
+		InstancePendingDelete, InstancePendingForceDelete, InstanceDeleting,
+	},
+	InstanceError: {
+		InstancePendingDelete, InstancePendingForceDelete, InstanceDeleting,
+	},
+	InstancePendingDelete: {
+		InstancePendingForceDelete, InstanceDeleting, InstanceError,
+	},
+	InstancePendingForceDelete: {
+		InstanceDeleting, InstanceError,
+	},
+	InstanceDeleting: {
+		InstancePendingForceDelete, InstanceError,
+	},
+}
+
+// CanTransition returns true if an instance is allowed to move from one status to the
+// other. For example, an instance that is being deleted can never go back to running.
+func CanTransition(from, to InstanceStatus) bool {
+	if from == to || from == InstanceStatusUnknown || to == InstanceStatusUnknown {
+		return true
+	}
+
+	for _, status := range instanceStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValid returns true if the status is one of the known instance statuses.
+func (s InstanceStatus) IsValid() bool {
+	if s == InstanceStatusUnknown {
+		return true
+	}
+	_, ok := instanceStatusTransitions[s]
+	return ok
+}
+
+// IsTerminal returns true if an instance in this status will never run again. The only
+// way out of a terminal status is for the instance to be deleted.
+func (s InstanceStatus) IsTerminal() bool {
+	switch s {
+	case InstanceError, InstancePendingDelete, InstancePendingForceDelete, InstanceDeleting:
+		return true
+	}
+	return false
+}
+
+// IsPending returns true if the instance is in the middle of being created or deleted.
+func (s InstanceStatus) IsPending() bool {
+	switch s {
+	case InstancePendingCreate, InstanceCreating,
+		InstancePendingDelete, InstancePendingForceDelete, InstanceDeleting:
+		return true
+	}
+	return false
+}
+
+// StatusMapper normalizes the states reported by an IaaS (eg: "PROVISIONING",
+// "TERMINATED") into instance statuses. Native states are matched case insensitively.
+type StatusMapper struct {
+	table map[string]InstanceStatus
+}
+
+// NewStatusMapper returns a StatusMapper that uses the given table, which maps native
+// states to instance statuses.
+func NewStatusMapper(table map[string]InstanceStatus) StatusMapper {
+	normalized := make(map[string]InstanceStatus, len(table))
+	for native, status := range table {
+		normalized[strings.ToLower(native)] = status
+	}
+	return StatusMapper{
+		table: normalized,
+	}
+}
+
+// Map returns the instance status that corresponds to the native state, or
+// InstanceStatusUnknown if the native state is not in the table.
+func (m StatusMapper) Map(native string) InstanceStatus {
+	status, ok := m.table[strings.ToLower(native)]
+	if !ok {
+		return InstanceStatusUnknown
+	}
+	return status
+}
+
+// Lookup is similar to Map, but also returns whether the native state was found.
+func (m StatusMapper) Lookup(native string) (InstanceStatus, bool) {
+	status, ok := m.table[strings.ToLower(native)]
+	return status, ok
+}
@@ -0,0 +1,61 @@
+package params
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from     InstanceStatus
+		to       InstanceStatus
+		expected bool
+	}{
+		{InstancePendingCreate, InstanceCreating, true},
+		{InstanceCreating, InstanceRunning, true},
+		{InstanceRunning, InstanceStopped, true},
+		{InstanceStopped, InstanceRunning, true},
+		{InstanceRunning, InstanceDeleting, true},
+		{InstanceRunning, InstanceRunning, true},
+		{InstanceRunning, InstanceStatusUnknown, true},
+		{InstanceStatusUnknown, InstanceRunning, true},
+		{InstanceDeleting, InstanceRunning, false},
+		{InstancePendingDelete, InstanceStopped, false},
+		{InstanceError, InstanceRunning, false},
+		{InstanceRunning, InstancePendingCreate, false},
+	}
+
+	for _, tc := range tests {
+		require.Equal(t, tc.expected, CanTransition(tc.from, tc.to), "%s -> %s", tc.from, tc.to)
+	}
+}
+
+func TestInstanceStatusHelpers(t *testing.T) {
+	require.True(t, InstanceDeleting.IsTerminal())
+	require.True(t, InstanceError.IsTerminal())
+	require.False(t, InstanceStopped.IsTerminal())
+
+	require.True(t, InstanceCreating.IsPending())
+	require.True(t, InstancePendingDelete.IsPending())
+	require.False(t, InstanceRunning.IsPending())
+
+	require.True(t, InstanceRunning.IsValid())
+	require.True(t, InstanceStatusUnknown.IsValid())
+	require.False(t, InstanceStatus("bogus").IsValid())
+}
+
+func TestStatusMapper(t *testing.T) {
+	mapper := NewStatusMapper(map[string]InstanceStatus{
+		"PROVISIONING": InstanceCreating,
+		"RUNNING":      InstanceRunning,
+		"TERMINATED":   InstanceStopped,
+	})
+
+	require.Equal(t, InstanceCreating, mapper.Map("PROVISIONING"))
+	require.Equal(t, InstanceStopped, mapper.Map("terminated"))
+	require.Equal(t, InstanceStatusUnknown, mapper.Map("SUSPENDED"))
+
+	_, ok := mapper.Lookup("SUSPENDED")
+	require.False(t, ok)
+}
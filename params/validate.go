@@ -0,0 +1,224 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package params
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxRunnerLabels is the maximum number of labels GitHub allows on a runner.
+	MaxRunnerLabels = 100
+	// MaxRunnerLabelLength is the maximum length of a runner label.
+	MaxRunnerLabelLength = 256
+)
+
+var hostnameRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9\-.]*[a-zA-Z0-9])?$`)
+
+// FieldError describes a single invalid field.
+type FieldError struct {
+	// Field is the JSON name of the field.
+	Field string `json:"field"`
+	// Message describes what is wrong with the field.
+	Message string `json:"message"`
+}
+
+func (f FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", f.Field, f.Message)
+}
+
+// ValidationErrors holds all the invalid fields of a payload.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, fieldErr := range v {
+		msgs[i] = fieldErr.Error()
+	}
+	return fmt.Sprintf("invalid fields: %s", strings.Join(msgs, "; "))
+}
+
+func (v *ValidationErrors) add(field, msg string, a ...interface{}) {
+	*v = append(*v, FieldError{
+		Field:   field,
+		Message: fmt.Sprintf(msg, a...),
+	})
+}
+
+// err returns the validation errors as an error, or nil if there are none.
+func (v ValidationErrors) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// IsValid returns true if the OS type is one of the supported OS types.
+func (o OSType) IsValid() bool {
+	switch o {
+	case Linux, Windows:
+		return true
+	}
+	return false
+}
+
+// IsValid returns true if the architecture is one of the supported architectures.
+func (o OSArch) IsValid() bool {
+	switch o {
+	case Amd64, I386, Arm64, Arm:
+		return true
+	}
+	return false
+}
+
+// IsValid returns true if the address type is known.
+func (a AddressType) IsValid() bool {
+	switch a {
+	case PublicAddress, PrivateAddress:
+		return true
+	}
+	return false
+}
+
+// Validate checks the bootstrap params and returns a ValidationErrors holding every
+// invalid field, or nil if the params are valid.
+func (b BootstrapInstance) Validate() error {
+	var errs ValidationErrors
+
+	if b.Name == "" {
+		errs.add("name", "missing instance name")
+	}
+
+	if !b.OSType.IsValid() {
+		errs.add("os_type", "unsupported OS type %q", b.OSType)
+	}
+
+	if !b.OSArch.IsValid() {
+		errs.add("arch", "unsupported architecture %q", b.OSArch)
+	}
+
+	if b.CallbackURL != "" {
+		if err := validateURL(b.CallbackURL); err != nil {
+			errs.add("callback-url", "%s", err)
+		}
+	}
+
+	if b.MetadataURL != "" {
+		if err := validateURL(b.MetadataURL); err != nil {
+			errs.add("metadata-url", "%s", err)
+		}
+	}
+
+	if len(b.CACertBundle) > 0 {
+		if err := validateCACertBundle(b.CACertBundle); err != nil {
+			errs.add("ca-cert-bundle", "%s", err)
+		}
+	}
+
+	if len(b.Labels) > MaxRunnerLabels {
+		errs.add("labels", "too many labels (%d), the maximum is %d", len(b.Labels), MaxRunnerLabels)
+	}
+	for _, label := range b.Labels {
+		switch {
+		case strings.TrimSpace(label) == "":
+			errs.add("labels", "empty label")
+		case len(label) > MaxRunnerLabelLength:
+			errs.add("labels", "label %q is longer than %d characters", label, MaxRunnerLabelLength)
+		case strings.Contains(label, ","):
+			errs.add("labels", "label %q must not contain commas", label)
+		}
+	}
+
+	if len(b.ExtraSpecs) > 0 && !json.Valid(b.ExtraSpecs) {
+		errs.add("extra_specs", "invalid JSON")
+	}
+
+	return errs.err()
+}
+
+// Validate checks the address is an IP or a hostname, with a known type.
+func (a Address) Validate() error {
+	if net.ParseIP(a.Address) == nil && !hostnameRegex.MatchString(a.Address) {
+		return fmt.Errorf("invalid address %q", a.Address)
+	}
+	if !a.Type.IsValid() {
+		return fmt.Errorf("invalid type %q for address %q", a.Type, a.Address)
+	}
+	return nil
+}
+
+// Validate checks the status and the addresses of the instance returned by a provider.
+// It returns a ValidationErrors holding every invalid field, or nil if the instance is
+// valid. Other fields are left to the provider, as not all providers can report them.
+func (p ProviderInstance) Validate() error {
+	var errs ValidationErrors
+
+	if p.Status != "" && !p.Status.IsValid() {
+		errs.add("status", "unknown status %q", p.Status)
+	}
+
+	for _, address := range p.Addresses {
+		if err := address.Validate(); err != nil {
+			errs.add("addresses", "%s", err)
+		}
+	}
+
+	return errs.err()
+}
+
+func validateURL(val string) error {
+	u, err := url.Parse(val)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in URL")
+	}
+	return nil
+}
+
+func validateCACertBundle(bundle []byte) error {
+	var found bool
+	rest := bundle
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("no certificates found")
+	}
+	return nil
+}
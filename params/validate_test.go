@@ -0,0 +1,114 @@
+package params
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCACert(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func validBootstrapInstance(t *testing.T) BootstrapInstance {
+	return BootstrapInstance{
+		Name:         "runner",
+		OSType:       Linux,
+		OSArch:       Amd64,
+		CallbackURL:  "https://garm.example.com/api/v1/callbacks",
+		MetadataURL:  "https://garm.example.com/api/v1/metadata",
+		CACertBundle: newTestCACert(t),
+		Labels:       []string{"self-hosted", "linux"},
+		ExtraSpecs:   json.RawMessage(`{"key": "value"}`),
+	}
+}
+
+func TestBootstrapInstanceValidate(t *testing.T) {
+	require.NoError(t, validBootstrapInstance(t).Validate())
+
+	bootstrap := validBootstrapInstance(t)
+	bootstrap.Name = ""
+	bootstrap.OSType = "plan9"
+	bootstrap.OSArch = ""
+	bootstrap.CallbackURL = "ftp://garm.example.com"
+	bootstrap.MetadataURL = "https://"
+	bootstrap.CACertBundle = []byte("not a certificate")
+	bootstrap.Labels = []string{"", "a,b", strings.Repeat("a", MaxRunnerLabelLength+1)}
+	bootstrap.ExtraSpecs = json.RawMessage(`{`)
+
+	err := bootstrap.Validate()
+	var validationErrs ValidationErrors
+	require.True(t, errors.As(err, &validationErrs))
+
+	var fields []string
+	for _, fieldErr := range validationErrs {
+		fields = append(fields, fieldErr.Field)
+	}
+	require.Equal(t, []string{
+		"name", "os_type", "arch", "callback-url", "metadata-url",
+		"ca-cert-bundle", "labels", "labels", "labels", "extra_specs",
+	}, fields)
+}
+
+func TestBootstrapInstanceValidateTooManyLabels(t *testing.T) {
+	bootstrap := validBootstrapInstance(t)
+	bootstrap.Labels = make([]string, MaxRunnerLabels+1)
+	for i := range bootstrap.Labels {
+		bootstrap.Labels[i] = "label"
+	}
+	require.ErrorContains(t, bootstrap.Validate(), "too many labels")
+}
+
+func TestProviderInstanceValidate(t *testing.T) {
+	instance := ProviderInstance{
+		ProviderID: "i-123",
+		Status:     InstanceRunning,
+		OSType:     Linux,
+		OSArch:     Arm64,
+		Addresses: []Address{
+			{Address: "10.0.0.1", Type: PrivateAddress},
+			{Address: "2001:db8::1", Type: PublicAddress},
+			{Address: "runner.example.com", Type: PublicAddress},
+		},
+	}
+	require.NoError(t, instance.Validate())
+
+	// Only the status and the addresses are checked.
+	instance.ProviderID = ""
+	instance.OSType = "plan9"
+	instance.OSArch = "sparc"
+	require.NoError(t, instance.Validate())
+
+	instance.Status = "booting"
+	instance.Addresses = []Address{{Address: "not an address", Type: PublicAddress}, {Address: "10.0.0.1", Type: "elastic"}}
+
+	err := instance.Validate()
+	var validationErrs ValidationErrors
+	require.True(t, errors.As(err, &validationErrs))
+	require.Len(t, validationErrs, 3)
+	require.Contains(t, err.Error(), `status: unknown status "booting"`)
+}
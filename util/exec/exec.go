@@ -23,6 +23,17 @@ import (
 )
 
 func Exec(ctx context.Context, providerBin string, stdinData []byte, environ []string) ([]byte, error) {
+	stdout, stderr, err := Run(ctx, providerBin, stdinData, environ)
+	if err != nil {
+		return nil, errors.Wrapf(err, "provider binary failed with stdout: %s; stderr: %s", stdout, stderr)
+	}
+
+	return stdout, nil
+}
+
+// Run executes the provider binary and returns its stdout and stderr. Unlike Exec, the
+// error is returned unwrapped, so callers can get the exit code from an *exec.ExitError.
+func Run(ctx context.Context, providerBin string, stdinData []byte, environ []string) ([]byte, []byte, error) {
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
 	c := exec.CommandContext(ctx, providerBin)
@@ -31,9 +42,6 @@ func Exec(ctx context.Context, providerBin string, stdinData []byte, environ []s
 	c.Stdout = stdout
 	c.Stderr = stderr
 
-	if err := c.Run(); err != nil {
-		return nil, errors.Wrapf(err, "provider binary failed with stdout: %s; stderr: %s", stdout.String(), stderr.String())
-	}
-
-	return stdout.Bytes(), nil
+	err := c.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
 }
@@ -49,23 +49,25 @@ var rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9
 
 var (
 	OSToOSTypeMap map[string]params.OSType = map[string]params.OSType{
-		"almalinux":  params.Linux,
-		"alma":       params.Linux,
-		"alpine":     params.Linux,
-		"archlinux":  params.Linux,
-		"arch":       params.Linux,
-		"centos":     params.Linux,
-		"ubuntu":     params.Linux,
-		"rhel":       params.Linux,
-		"suse":       params.Linux,
-		"opensuse":   params.Linux,
-		"fedora":     params.Linux,
-		"debian":     params.Linux,
-		"flatcar":    params.Linux,
-		"gentoo":     params.Linux,
-		"rockylinux": params.Linux,
-		"rocky":      params.Linux,
-		"windows":    params.Windows,
+		"almalinux":     params.Linux,
+		"alma":          params.Linux,
+		"alpine":        params.Linux,
+		"archlinux":     params.Linux,
+		"arch":          params.Linux,
+		"centos":        params.Linux,
+		"ubuntu":        params.Linux,
+		"rhel":          params.Linux,
+		"suse":          params.Linux,
+		"opensuse":      params.Linux,
+		"fedora":        params.Linux,
+		"debian":        params.Linux,
+		"flatcar":       params.Linux,
+		"fcos":          params.Linux,
+		"fedora-coreos": params.Linux,
+		"gentoo":        params.Linux,
+		"rockylinux":    params.Linux,
+		"rocky":         params.Linux,
+		"windows":       params.Windows,
 	}
 
 	githubArchMapping map[string]string = map[string]string{